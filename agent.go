@@ -0,0 +1,260 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/nsvirk/totp-cli/config"
+)
+
+// agentSocketEnvVar overrides where the agent listens and clients connect
+const agentSocketEnvVar = "TOTP_AGENT_SOCK"
+
+// defaultAgentTimeout is how long the agent keeps the passphrase without
+// being asked for it, see --timeout
+const defaultAgentTimeout = 15 * time.Minute
+
+// agentRequest is what a client sends the agent, one JSON object per
+// connection
+type agentRequest struct {
+	// Config is the absolute path of the config file to decrypt
+	Config string `json:"config,omitempty"`
+	// Stop asks the agent to wipe the passphrase and exit
+	Stop bool `json:"stop,omitempty"`
+}
+
+// agentResponse is the agent's answer to an agentRequest
+type agentResponse struct {
+	Passphrase string `json:"passphrase,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// agentSocketPath returns the agent's socket: TOTP_AGENT_SOCK, or agent.sock
+// in a private directory under $XDG_RUNTIME_DIR or the temp directory
+func agentSocketPath() string {
+	if path := os.Getenv(agentSocketEnvVar); path != "" {
+		return path
+	}
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "totp-cli", "agent.sock")
+	}
+	return filepath.Join(os.TempDir(), "totp-cli-"+strconv.Itoa(os.Getuid()), "agent.sock")
+}
+
+// canonicalPath resolves a config path the same way in the agent and its
+// clients, so either spelling of a file matches
+func canonicalPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+	return path
+}
+
+// agentPassphrase asks a running agent for the passphrase of the config
+// file, reporting false if there's no agent or it doesn't hold this one
+func agentPassphrase(configPath string) (string, bool) {
+	conn, err := net.DialTimeout("unix", agentSocketPath(), time.Second)
+	if err != nil {
+		return "", false
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+	if err := json.NewEncoder(conn).Encode(agentRequest{Config: canonicalPath(configPath)}); err != nil {
+		return "", false
+	}
+	var response agentResponse
+	if err := json.NewDecoder(conn).Decode(&response); err != nil || response.Passphrase == "" {
+		return "", false
+	}
+	return response.Passphrase, true
+}
+
+// runAgent keeps the passphrase of an encrypted config in memory and hands
+// it to other invocations over a Unix socket, so they don't prompt for it.
+// It runs in the foreground until it's been idle for the timeout, stopped
+// with --stop or interrupted, and wipes the passphrase before exiting.
+func runAgent(args []string) {
+	var configFlag, timeoutFlag string
+	var stop bool
+	positional, err := parseCommandArgs(args,
+		map[string]*bool{"--stop": &stop},
+		map[string]*string{"--config": &configFlag, "--timeout": &timeoutFlag})
+	if err != nil {
+		fatal(fmt.Sprintf("Error: %v", err))
+	}
+	if len(positional) != 0 {
+		fatal("Usage: agent [--timeout <duration>] [--config <path>] | agent --stop")
+	}
+	socketPath := agentSocketPath()
+	if stop {
+		stopAgent(socketPath)
+		return
+	}
+
+	timeout := defaultAgentTimeout
+	if timeoutFlag != "" {
+		if timeout, err = time.ParseDuration(timeoutFlag); err != nil || timeout <= 0 {
+			fatal(fmt.Sprintf("Invalid --timeout value '%s': must be a positive duration like 15m or 1h", timeoutFlag))
+		}
+	}
+
+	configPath := canonicalPath(commandConfigPath(configFlag))
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		failWith(exitConfigMissing, []string{"config", configPath}, fmt.Sprintf("Error: config file not found: %s", configPath))
+	}
+	if !config.IsEncrypted(data) {
+		fatal(fmt.Sprintf("Error: %s isn't encrypted, so there's no passphrase to keep", configPath))
+	}
+	passphrase := os.Getenv(passphraseEnvVar)
+	if passphrase == "" {
+		if passphrase, err = promptPassphrase("🔒 Config passphrase: "); err != nil {
+			fatal(fmt.Sprintf("Error: %v", err))
+		}
+	}
+	if _, err := config.Decrypt(data, passphrase); err != nil {
+		fatal(fmt.Sprintf("Error: %v", err))
+	}
+	key := []byte(passphrase)
+	passphrase = ""
+
+	listener, err := listenAgent(socketPath)
+	if err != nil {
+		fatal(fmt.Sprintf("Error: %v", err))
+	}
+	shutdown := func(message string) {
+		for i := range key {
+			key[i] = 0
+		}
+		listener.Close()
+		os.Remove(socketPath)
+		fmt.Println(message)
+	}
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-interrupt
+		shutdown("🧹 Agent stopped, passphrase wiped")
+		os.Exit(0)
+	}()
+
+	fmt.Printf("🔑 Holding the passphrase for %s at %s (wiped after %s idle)\n", configPath, socketPath, timeout)
+	for {
+		listener.SetDeadline(time.Now().Add(timeout))
+		conn, err := listener.Accept()
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			shutdown(fmt.Sprintf("⌛ Agent idle for %s, passphrase wiped", timeout))
+			return
+		}
+		if err != nil {
+			shutdown(fmt.Sprintf("⚠️ Agent stopped: %v", err))
+			os.Exit(exitError)
+		}
+		if stopRequested := serveAgentRequest(conn, configPath, key); stopRequested {
+			shutdown("🧹 Agent stopped, passphrase wiped")
+			return
+		}
+	}
+}
+
+// listenAgent creates the agent's socket in a directory only the user can
+// write to, replacing a stale socket left by an agent that died. A missing
+// directory is created private; an existing one is never changed, but
+// refused if someone else could replace the socket in it.
+func listenAgent(socketPath string) (*net.UnixListener, error) {
+	dir := filepath.Dir(socketPath)
+	if _, err := os.Stat(dir); errors.Is(err, os.ErrNotExist) {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return nil, err
+		}
+	}
+	if err := checkAgentDir(dir); err != nil {
+		return nil, err
+	}
+	if conn, err := net.DialTimeout("unix", socketPath, time.Second); err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("an agent is already running at %s (stop it with: totp agent --stop)", socketPath)
+	}
+	os.Remove(socketPath)
+
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: socketPath, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(socketPath, 0600); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// checkAgentDir makes sure the socket's directory belongs to the user and
+// isn't writable by anyone else
+func checkAgentDir(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	switch {
+	case !info.IsDir():
+		return fmt.Errorf("%s is not a directory", dir)
+	case !ownedByUser(info):
+		return fmt.Errorf("refusing to put the agent socket in %s, which belongs to another user", dir)
+	case runtime.GOOS != "windows" && info.Mode().Perm()&0022 != 0:
+		return fmt.Errorf("refusing to put the agent socket in %s, which other users can write to (mode %04o)", dir, info.Mode().Perm())
+	}
+	return nil
+}
+
+// serveAgentRequest answers one client, handing out the passphrase only for
+// the config file it belongs to. It reports whether the client asked the
+// agent to stop.
+func serveAgentRequest(conn net.Conn, configPath string, key []byte) bool {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+	var request agentRequest
+	if err := json.NewDecoder(conn).Decode(&request); err != nil {
+		return false
+	}
+	var response agentResponse
+	switch {
+	case request.Stop:
+	case request.Config == configPath:
+		response.Passphrase = string(key)
+	default:
+		response.Error = fmt.Sprintf("this agent holds the passphrase for %s", configPath)
+	}
+	json.NewEncoder(conn).Encode(response)
+	return request.Stop
+}
+
+// stopAgent asks the running agent to wipe its passphrase and exit
+func stopAgent(socketPath string) {
+	conn, err := net.DialTimeout("unix", socketPath, time.Second)
+	if err != nil {
+		fatal(fmt.Sprintf("Error: no agent running at %s", socketPath))
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	if err := json.NewEncoder(conn).Encode(agentRequest{Stop: true}); err != nil {
+		fatal(fmt.Sprintf("Error: %v", err))
+	}
+	var response agentResponse
+	json.NewDecoder(conn).Decode(&response)
+	fmt.Println("🧹 Agent stopped")
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestListenAgentDirectory(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("directory modes aren't checked on Windows")
+	}
+
+	// A missing directory is created for the user only
+	dir := filepath.Join(t.TempDir(), "agent")
+	listener, err := listenAgent(filepath.Join(dir, "agent.sock"))
+	if err != nil {
+		t.Fatalf("listenAgent in a new directory: %v", err)
+	}
+	listener.Close()
+	if info, err := os.Stat(dir); err != nil || info.Mode().Perm()&0077 != 0 {
+		t.Errorf("created directory: %v, %v, want mode 0700", info.Mode(), err)
+	}
+
+	// An existing directory others can write to is refused, and left alone
+	shared := t.TempDir()
+	if err := os.Chmod(shared, 0777); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := listenAgent(filepath.Join(shared, "agent.sock")); err == nil || !strings.Contains(err.Error(), "other users can write to") {
+		t.Errorf("listenAgent in a world-writable directory = %v, want a refusal", err)
+	}
+	if info, _ := os.Stat(shared); info.Mode().Perm() != 0777 {
+		t.Errorf("shared directory mode changed to %04o", info.Mode().Perm())
+	}
+
+	// A readable but private-to-write one is fine
+	readable := t.TempDir()
+	if err := os.Chmod(readable, 0755); err != nil {
+		t.Fatal(err)
+	}
+	listener, err = listenAgent(filepath.Join(readable, "agent.sock"))
+	if err != nil {
+		t.Fatalf("listenAgent in a 0755 directory: %v", err)
+	}
+	listener.Close()
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/nsvirk/totp-cli/config"
+)
+
+// allCodesRow is one user's line in the --all table, and its JSON form
+type allCodesRow struct {
+	User string `json:"user"`
+	// Rotation names the secret of the row during a rotation, which gets a
+	// row per secret like the single-user output
+	Rotation  string `json:"rotation,omitempty"`
+	Code      string `json:"code,omitempty"`
+	ExpiresIn int    `json:"expires_in,omitempty"`
+	Skipped   bool   `json:"skipped,omitempty"`
+	Error     string `json:"error,omitempty"`
+
+	// entry is what the code came from, for the audit log
+	entry config.Entry
+}
+
+// allCodes generates the current code of every user in the config. Entries
+// that can't produce a code are noted instead of stopping the run, and HOTP
+// users are skipped so their counters don't advance.
+func allCodes(configPath string) ([]allCodesRow, error) {
+	raw, err := loadRawEntries(configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	users := make([]string, 0, len(raw))
+	for user := range raw {
+		users = append(users, user)
+	}
+	sort.Strings(users)
+
+	rows := make([]allCodesRow, 0, len(users))
+	for _, user := range users {
+		entry, err := parseEntry(raw[user])
+		switch {
+		case err != nil:
+			rows = append(rows, allCodesRow{User: user, Error: err.Error()})
+		case entry.IsHOTP():
+			rows = append(rows, allCodesRow{User: user, Skipped: true, Error: "HOTP, skipped so the counter doesn't advance"})
+		default:
+			for _, rotated := range entry.Rotated() {
+				row := allCodesRow{User: user, Rotation: rotated.Rotation, entry: rotated}
+				if row.Code, err = entryCode(rotated, 0); err != nil {
+					row.Error = err.Error()
+				} else {
+					row.ExpiresIn = secondsRemaining(rotated.Options().Period)
+				}
+				rows = append(rows, row)
+			}
+		}
+	}
+	return rows, nil
+}
+
+// printAllCodes prints the --all table, exiting with exitInvalidSecret if
+// any entry is broken. Nothing is copied to the clipboard.
+func printAllCodes(configPath string) {
+	rows, err := allCodes(configPath)
+	if err != nil {
+		failWith(configExitCode(err), []string{"config", configPath}, fmt.Sprintf("Error: %v", err))
+	}
+
+	broken := false
+	var userIDs []string
+	var entries []config.Entry
+	for _, row := range rows {
+		if row.Error != "" && !row.Skipped {
+			broken = true
+		}
+		if row.Code != "" {
+			userIDs = append(userIDs, row.User)
+			entries = append(entries, row.entry)
+		}
+	}
+	if err := auditCodes(configPath, userIDs, entries); err != nil {
+		fatal(fmt.Sprintf("Error: %v", err))
+	}
+
+	if jsonOutput {
+		printJSON(os.Stdout, rows)
+	} else {
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "USER\tCODE\tEXPIRES")
+		for _, row := range rows {
+			user := row.User
+			if row.Rotation != "" {
+				user += " (" + row.Rotation + ")"
+			}
+			if row.Error != "" {
+				fmt.Fprintf(w, "%s\t-\t%s\n", user, row.Error)
+			} else {
+				fmt.Fprintf(w, "%s\t%s\t%ds\n", user, displayCode(row.Code), row.ExpiresIn)
+			}
+		}
+		w.Flush()
+	}
+	if broken {
+		os.Exit(exitInvalidSecret)
+	}
+}
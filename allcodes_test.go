@@ -0,0 +1,37 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// A rotating user gets a row per secret, like the single-user output
+func TestAllCodesRotation(t *testing.T) {
+	t.Setenv(configCmdEnvVar, "")
+	path := filepath.Join(t.TempDir(), "config.json")
+	content := `{"github": ["JBSWY3DPEHPK3PXP", "GEZDGNBVGY3TQOJQ"], "gitlab": "JBSWY3DPEHPK3PXP"}`
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := allCodes(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct{ user, rotation string }{{"github", "old"}, {"github", "new"}, {"gitlab", ""}}
+	if len(rows) != len(want) {
+		t.Fatalf("allCodes returned %d rows, want %d: %+v", len(rows), len(want), rows)
+	}
+	for i, row := range rows {
+		if row.User != want[i].user || row.Rotation != want[i].rotation || row.Code == "" || row.Error != "" {
+			t.Errorf("row %d = %+v, want user %q, rotation %q with a code", i, row, want[i].user, want[i].rotation)
+		}
+	}
+	if rows[0].Code == rows[1].Code {
+		t.Errorf("old and new secrets gave the same code %s", rows[0].Code)
+	}
+	if rows[0].Code != rows[2].Code {
+		t.Errorf("old secret's code %s, want %s like gitlab, which has the same secret", rows[0].Code, rows[2].Code)
+	}
+}
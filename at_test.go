@@ -0,0 +1,33 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseAtTime(t *testing.T) {
+	for _, tt := range []struct {
+		value string
+		unix  int64
+		err   string
+	}{
+		{"0", 0, ""},
+		{"1704207845", 1704207845, ""},
+		{"2024-01-02T15:04:05Z", 1704207845, ""},
+		{"2024-01-02T16:04:05+01:00", 1704207845, ""},
+		{"1970-01-01T00:00:00Z", 0, ""},
+		{"-1", 0, "must not be before 1970"},
+		{"1969-12-31T23:59:59Z", 0, "must not be before 1970"},
+		{"yesterday", 0, "must be an RFC 3339 time"},
+	} {
+		got, err := parseAtTime(tt.value)
+		switch {
+		case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err) || !strings.Contains(err.Error(), "--at")):
+			t.Errorf("parseAtTime(%q) error = %v, want one about --at containing %q", tt.value, err, tt.err)
+		case tt.err == "" && err != nil:
+			t.Errorf("parseAtTime(%q): %v", tt.value, err)
+		case tt.err == "" && got.Unix() != tt.unix:
+			t.Errorf("parseAtTime(%q) = %d, want %d", tt.value, got.Unix(), tt.unix)
+		}
+	}
+}
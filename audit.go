@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nsvirk/totp-cli/config"
+)
+
+// auditFlag turns on the audit log for this run, set by --audit
+var auditFlag bool
+
+// auditFileName is the default audit log, in the totp-cli directory of the
+// user's state directory (e.g. ~/.local/state/totp-cli/audit.log)
+const auditFileName = "audit.log"
+
+// defaultAuditPath returns where the audit log goes when neither the config
+// nor anything else names a file
+func defaultAuditPath() (string, error) {
+	if runtime.GOOS == "windows" {
+		dir, err := os.UserConfigDir()
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(dir, "totp-cli", auditFileName), nil
+	}
+	if dir := os.Getenv("XDG_STATE_HOME"); filepath.IsAbs(dir) {
+		return filepath.Join(dir, "totp-cli", auditFileName), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".local", "state", "totp-cli", auditFileName), nil
+}
+
+// auditLogPath returns the audit log file, and "" when auditing is off. The
+// _audit key of the config file, or of the last extra config file that has
+// one, turns it on with true or a path, which is relative to that config
+// file. --audit turns it on even if the config doesn't. The settings are
+// those readSettings loaded for the run.
+func auditLogPath(settings configSettings) (string, error) {
+	path, enabled := "", auditFlag
+	for _, setting := range settings[config.AuditKey] {
+		configFile, value := setting.path, setting.value
+		var on bool
+		var file string
+		switch {
+		case json.Unmarshal(value, &on) == nil:
+			path, enabled = "", on || auditFlag
+		case json.Unmarshal(value, &file) == nil && file != "":
+			if !filepath.IsAbs(file) {
+				file = filepath.Join(filepath.Dir(configFile), file)
+			}
+			path, enabled = file, true
+		default:
+			return "", fmt.Errorf("%s in %s must be true, false or the path of the log file", config.AuditKey, configFile)
+		}
+	}
+	if !enabled {
+		return "", nil
+	}
+	if path == "" {
+		return defaultAuditPath()
+	}
+	return path, nil
+}
+
+// auditLogin names the person generating codes: the login user, and the one
+// who ran sudo to become it
+func auditLogin() string {
+	login := os.Getenv("USER")
+	if current, err := user.Current(); err == nil {
+		login = current.Username
+	}
+	if sudoUser := os.Getenv("SUDO_USER"); sudoUser != "" && sudoUser != login {
+		return fmt.Sprintf("login=%s sudo_user=%s", strconv.Quote(login), strconv.Quote(sudoUser))
+	}
+	return "login=" + strconv.Quote(login)
+}
+
+// auditCodes appends one line per user to the audit log, if it's on, before
+// their codes are shown or copied. Only who, when and which account are
+// written, never a code or a secret. The log is created readable by the user
+// only and tightened if it isn't, and since it's only worth something if it's
+// complete, a failed write stops the codes from being shown at all.
+func auditCodes(configPath string, userIDs []string, entries []config.Entry) error {
+	if configPath == "" {
+		// One-off secrets don't belong to an account
+		return nil
+	}
+	path, err := auditLogPath(readSettings(configPath))
+	if err != nil || path == "" {
+		return err
+	}
+	if err := appendAuditLines(path, configPath, userIDs, entries); err != nil {
+		return fmt.Errorf("could not write the audit log %s: %v", path, err)
+	}
+	return nil
+}
+
+// appendAuditLines writes the audit log lines of one run
+func appendAuditLines(path, configPath string, userIDs []string, entries []config.Entry) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if info, err := f.Stat(); err == nil && runtime.GOOS != "windows" && info.Mode().Perm()&0077 != 0 {
+		if err := f.Chmod(0600); err != nil {
+			return err
+		}
+		warn(fmt.Sprintf("%s was accessible by other users (mode %04o), changed it to 0600", path, info.Mode().Perm()))
+	}
+
+	stamp := time.Now().UTC().Format(time.RFC3339)
+	login := auditLogin()
+	var b strings.Builder
+	for i, entry := range entries {
+		if i > 0 && userIDs[i] == userIDs[i-1] {
+			// The secrets of a rotation are one account
+			continue
+		}
+		kind := "totp"
+		switch {
+		case entry.IsHOTP():
+			kind = "hotp"
+		case entry.IsSteam():
+			kind = "steam"
+		}
+		fmt.Fprintf(&b, "%s %s account=%s type=%s config=%s", stamp, login, strconv.Quote(userIDs[i]), kind, strconv.Quote(canonicalPath(configPath)))
+		if atTime != nil {
+			fmt.Fprintf(&b, " at=%s", time.Unix(now(), 0).UTC().Format(time.RFC3339))
+		}
+		b.WriteString("\n")
+	}
+	_, err = f.WriteString(b.String())
+	return err
+}
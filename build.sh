@@ -4,8 +4,11 @@
 
 echo "Building CLI TOTP generator..."
 
-# Build for current platform
-go build -o totp main.go
+# Build for current platform, recording the version for --version
+VERSION=$(git describe --tags --always --dirty 2>/dev/null || echo dev)
+COMMIT=$(git rev-parse --short HEAD 2>/dev/null)
+DATE=$(date -u +%Y-%m-%dT%H:%M:%SZ)
+go build -ldflags "-X main.version=$VERSION -X main.commit=$COMMIT -X main.date=$DATE" -o totp .
 
 echo "Build complete!"
 
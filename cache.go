@@ -0,0 +1,93 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/nsvirk/totp-cli/config"
+)
+
+// cacheFileName is the --cache file, in the totp-cli directory of the user's
+// cache directory (e.g. ~/.cache/totp-cli/codes.json)
+const cacheFileName = "codes.json"
+
+// cachedCodeEntry is a code in the cache file, kept until its time step ends
+type cachedCodeEntry struct {
+	Code    string `json:"code"`
+	Expires int64  `json:"expires"`
+}
+
+// cachePath returns the location of the --cache file
+func cachePath() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("could not get cache directory: %v", err)
+	}
+	return filepath.Join(dir, "totp-cli", cacheFileName), nil
+}
+
+// cacheKey identifies a user's code for one time step. Secrets and user IDs
+// are only stored hashed, and a changed secret or setting gets a new key.
+func cacheKey(user string, entry config.Entry, step int64) string {
+	opts := entry.Options()
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%s\x00%s\x00%d\x00%d\x00%t\x00%d", user, entry.Secret, opts.Encoding, opts.Algorithm, opts.Digits, opts.Period, entry.IsSteam(), step)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// cachedCode returns the current code of a time-based entry from the cache,
+// generating and storing it when this time step hasn't been seen yet. A cache
+// that can't be read or written only costs the regeneration, with a warning.
+func cachedCode(user string, entry config.Entry) (string, error) {
+	period := int64(entry.Options().Period)
+	step := now() / period
+	key := cacheKey(user, entry, step)
+
+	path, err := cachePath()
+	if err != nil {
+		warn(fmt.Sprintf("Not caching codes: %v", err))
+		return entryCode(entry, 0)
+	}
+	cache := make(map[string]cachedCodeEntry)
+	if data, err := os.ReadFile(path); err == nil {
+		if err := json.Unmarshal(data, &cache); err != nil {
+			// Start over rather than fail on a damaged cache
+			cache = make(map[string]cachedCodeEntry)
+		}
+	}
+	if cached, ok := cache[key]; ok && cached.Expires > now() {
+		return cached.Code, nil
+	}
+
+	code, err := entryCode(entry, 0)
+	if err != nil {
+		return "", err
+	}
+	for k, cached := range cache {
+		if cached.Expires <= now() {
+			delete(cache, k)
+		}
+	}
+	cache[key] = cachedCodeEntry{Code: code, Expires: (step + 1) * period}
+	if err := writeCache(path, cache); err != nil {
+		warn(fmt.Sprintf("Could not update code cache: %v", err))
+	}
+	return code, nil
+}
+
+// writeCache saves the cache readable by the user only, since it holds codes
+// that are valid until they expire
+func writeCache(path string, cache map[string]cachedCodeEntry) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	data, err := json.Marshal(cache)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data, 0600)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/nsvirk/totp-cli/config"
+)
+
+func TestCreateCaseInsensitiveMapCollisions(t *testing.T) {
+	cfg := config.Config{
+		"GitHub": {Secret: "JBSWY3DPEHPK3PXP"},
+		"github": {Secret: "GEZDGNBVGY3TQOJQ"},
+		"GITHUB": {Secret: "MZXW6YTBOI"},
+		"AWS":    {Secret: "JBSWY3DPEHPK3PXP"},
+		"aws":    {Secret: "GEZDGNBVGY3TQOJQ"},
+		"vpn":    {Secret: "JBSWY3DPEHPK3PXP"},
+	}
+	lookup, collisions := createCaseInsensitiveMap(cfg)
+
+	// Every spelling is reported together, in sorted order, once per group
+	want := [][]string{{"AWS", "aws"}, {"GITHUB", "GitHub", "github"}}
+	if !reflect.DeepEqual(collisions, want) {
+		t.Errorf("collisions = %v, want %v", collisions, want)
+	}
+	// The case-insensitive lookup consistently picks the first spelling
+	wantLookup := map[string]string{"aws": "AWS", "github": "GITHUB", "vpn": "vpn"}
+	if !reflect.DeepEqual(lookup, wantLookup) {
+		t.Errorf("lookup = %v, want %v", lookup, wantLookup)
+	}
+}
+
+func TestCreateCaseInsensitiveMapNoCollisions(t *testing.T) {
+	cfg := config.Config{
+		"GitHub":      {Secret: "JBSWY3DPEHPK3PXP"},
+		"work/GitHub": {Secret: "GEZDGNBVGY3TQOJQ"},
+	}
+	lookup, collisions := createCaseInsensitiveMap(cfg)
+	if len(collisions) != 0 {
+		t.Errorf("collisions = %v, want none", collisions)
+	}
+	if lookup["github"] != "GitHub" || lookup["work/github"] != "work/GitHub" {
+		t.Errorf("lookup = %v", lookup)
+	}
+}
+
+// Users that collide can still be reached by their exact spelling
+func TestLookupUserExactCaseAfterCollision(t *testing.T) {
+	cfg := config.Config{
+		"GitHub": {Secret: "JBSWY3DPEHPK3PXP"},
+		"github": {Secret: "GEZDGNBVGY3TQOJQ"},
+	}
+	lookup, _ := createCaseInsensitiveMap(cfg)
+	for _, user := range []string{"GitHub", "github"} {
+		got, entry := lookupUser(cfg, lookup, "config.json", user)
+		if got != user || entry.Secret != cfg[user].Secret {
+			t.Errorf("lookupUser(%s) = %s, want the exact match", user, got)
+		}
+	}
+	if got, _ := lookupUser(cfg, lookup, "config.json", "GITHUB"); got != "GitHub" {
+		t.Errorf("lookupUser(GITHUB) = %s, want GitHub", got)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// colorOutput turns on ANSI colors in the human-readable output. It's set
+// at startup when stdout is a terminal, unless NO_COLOR is set
+// (https://no-color.org) or the terminal is dumb.
+var colorOutput bool
+
+// ANSI escape sequences for the colors used in the output
+const (
+	ansiReset  = "\033[0m"
+	ansiCyan   = "\033[36m"
+	ansiGreen  = "\033[1;32m"
+	ansiYellow = "\033[33m"
+)
+
+// lowExpirySeconds is how close to expiring a code has to be for its expiry
+// to be shown in the warning color
+const lowExpirySeconds = 10
+
+// fieldWidth is the width the labels of the code block are padded to, so
+// the values line up whatever the label
+const fieldWidth = len("Expires in")
+
+// useColor reports whether the output should be colored
+func useColor() bool {
+	if os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	return isTerminal(os.Stdout)
+}
+
+// colorize wraps text in an ANSI color when colorOutput is set
+func colorize(color, text string) string {
+	if !colorOutput || color == "" {
+		return text
+	}
+	return color + text + ansiReset
+}
+
+// expiryColor returns the color of a code's remaining lifetime, the warning
+// color once it's about to expire
+func expiryColor(remaining int) string {
+	if remaining <= lowExpirySeconds {
+		return ansiYellow
+	}
+	return ""
+}
+
+// formatField formats a line of the code block, like "🔑 TOTP Code  : 123456"
+func formatField(emoji, label, value string) string {
+	return fmt.Sprintf("%s %-*s : %s", emoji, fieldWidth, label, value)
+}
@@ -0,0 +1,493 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nsvirk/totp-cli/config"
+	"github.com/nsvirk/totp-cli/totp"
+)
+
+// commands maps subcommand names to their implementations. Each receives
+// the arguments following the subcommand name.
+var commands = map[string]func(args []string){
+	"add":             runAdd,
+	"remove":          runRemove,
+	"rename":          runRename,
+	"import-google":   runImportGoogle,
+	"qr":              runQR,
+	"keychain-add":    runKeychainAdd,
+	"verify":          runVerify,
+	"migrate":         runMigrate,
+	"info":            runInfo,
+	"export":          runExport,
+	"search":          runSearch,
+	"prune":           runPrune,
+	"selftest":        runSelftest,
+	"agent":           runAgent,
+	"generate-secret": runGenerateSecret,
+}
+
+// parseCommandArgs splits subcommand arguments into positional arguments and
+// the given flags. Boolean flags are set to true when present, value flags
+// consume the following argument.
+func parseCommandArgs(args []string, bools map[string]*bool, values map[string]*string) ([]string, error) {
+	var positional []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if flag, ok := bools[arg]; ok {
+			*flag = true
+			continue
+		}
+		if flag, ok := values[arg]; ok {
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("%s requires a value", arg)
+			}
+			i++
+			*flag = args[i]
+			continue
+		}
+		if arg == "--json" {
+			jsonOutput = true
+			continue
+		}
+		if arg == "--porcelain" {
+			porcelainOutput = true
+			continue
+		}
+		if strings.HasPrefix(arg, "-") {
+			return nil, fmt.Errorf("unknown option: %s", arg)
+		}
+		positional = append(positional, arg)
+	}
+	return positional, nil
+}
+
+// commandConfigPath resolves the config path for a subcommand, exiting on error
+func commandConfigPath(configFlag string) string {
+	configPath, _, err := resolveConfigPath(configFlag)
+	if err != nil {
+		fatal(fmt.Sprintf("Error: %v", err))
+	}
+	return configPath
+}
+
+// runAdd adds a user to the config file after checking that its secret works
+func runAdd(args []string) {
+	var configFlag, expectIssuer string
+	var force bool
+	positional, err := parseCommandArgs(args,
+		map[string]*bool{"--force": &force},
+		map[string]*string{"--config": &configFlag, "--expect-issuer": &expectIssuer})
+	if err != nil {
+		fatal(fmt.Sprintf("Error: %v", err))
+	}
+	if len(positional) != 2 {
+		fatal("Usage: add <user_id> <secret> [--force] [--expect-issuer <name>] [--config <path>]")
+	}
+	user, secret := positional[0], positional[1]
+	if config.IsReserved(user) {
+		fatal(fmt.Sprintf("Error: '%s' is reserved for comments and settings, pick another user ID", user))
+	}
+	configPath := commandConfigPath(configFlag)
+
+	// Guard against enrolling a URI meant for another service
+	if expectIssuer != "" {
+		if !strings.HasPrefix(secret, "otpauth://") {
+			fatal("--expect-issuer only applies when adding an otpauth:// URI")
+		}
+		issuer, err := config.OTPAuthIssuer(secret)
+		if err != nil {
+			failWith(exitInvalidSecret, []string{"user", user}, fmt.Sprintf("Error: invalid otpauth URI: %v", err))
+		}
+		if issuer == "" {
+			failWith(exitInvalidSecret, []string{"user", user, "expected_issuer", expectIssuer}, fmt.Sprintf("Error: the URI has no issuer, expected '%s'; nothing was saved", expectIssuer))
+		}
+		if !strings.EqualFold(strings.TrimSpace(issuer), strings.TrimSpace(expectIssuer)) {
+			failWith(exitInvalidSecret, []string{"user", user, "issuer", issuer, "expected_issuer", expectIssuer}, fmt.Sprintf("Error: the URI's issuer is '%s', not '%s'; nothing was saved", issuer, expectIssuer))
+		}
+	}
+
+	// Make sure the secret can actually produce a code before saving it
+	value, err := config.Marshal(secret, "")
+	if err != nil {
+		fatal(fmt.Sprintf("Error: %v", err))
+	}
+	entry, err := config.ParseEntry(value)
+	if err == nil {
+		err = entry.Check()
+	}
+	if err != nil {
+		failWith(exitInvalidSecret, []string{"user", user}, fmt.Sprintf("Error: invalid secret for user '%s': %v", user, err))
+	}
+	// Saved anyway, but a truncated paste is best caught now
+	if message := shortKeyMessage(user, entry); message != "" {
+		warn(message)
+	}
+
+	err = updateRawConfig(configPath, true, func(raw map[string]json.RawMessage) error {
+		if key, ok := findKey(raw, user); ok {
+			if !force {
+				return fmt.Errorf("user '%s' already exists (use --force to replace it)", key)
+			}
+			// Replace the existing entry instead of adding a second spelling
+			delete(raw, key)
+		}
+		raw[user] = value
+		return nil
+	})
+	if err != nil {
+		fatal(fmt.Sprintf("Error: %v", err))
+	}
+	fmt.Printf("✅ Added '%s' to %s\n", user, configPath)
+	printFingerprint(entry)
+}
+
+// printFingerprint confirms which secret was stored without showing it
+func printFingerprint(entry config.Entry) {
+	if fingerprint, err := totp.EncodedFingerprint(entry.Secret, entry.Encoding); err == nil {
+		fmt.Printf("🔑 Secret fingerprint: %s\n", fingerprint)
+	}
+}
+
+// runRemove removes a user from the config file
+func runRemove(args []string) {
+	var configFlag string
+	positional, err := parseCommandArgs(args, nil, map[string]*string{"--config": &configFlag})
+	if err != nil {
+		fatal(fmt.Sprintf("Error: %v", err))
+	}
+	if len(positional) != 1 {
+		fatal("Usage: remove <user_id> [--config <path>]")
+	}
+	user := positional[0]
+	configPath := commandConfigPath(configFlag)
+	if _, err := os.Stat(configPath); err != nil {
+		failWith(exitConfigMissing, []string{"config", configPath}, fmt.Sprintf("Error: config file not found: %s", configPath))
+	}
+
+	var removed string
+	notFound := false
+	err = updateRawConfig(configPath, false, func(raw map[string]json.RawMessage) error {
+		key, ok := findKey(raw, user)
+		if !ok {
+			notFound = true
+			return fmt.Errorf("user '%s' not found in config file at %s", user, configPath)
+		}
+		delete(raw, key)
+		removed = key
+		return nil
+	})
+	if notFound {
+		failWith(exitUserNotFound, []string{"user", user, "config", configPath}, fmt.Sprintf("Error: %v", err))
+	}
+	if err != nil {
+		fatal(fmt.Sprintf("Error: %v", err))
+	}
+	fmt.Printf("🗑️ Removed '%s' from %s\n", removed, configPath)
+}
+
+// runRename moves a user's entry, with all of its settings, to a new user
+// ID. An existing user with the new ID is only replaced with --force, and a
+// _default naming the old ID follows the rename.
+func runRename(args []string) {
+	var configFlag string
+	var force bool
+	positional, err := parseCommandArgs(args,
+		map[string]*bool{"--force": &force},
+		map[string]*string{"--config": &configFlag})
+	if err != nil {
+		fatal(fmt.Sprintf("Error: %v", err))
+	}
+	if len(positional) != 2 {
+		fatal("Usage: rename <old_user_id> <new_user_id> [--force] [--config <path>]")
+	}
+	oldUser, newUser := positional[0], positional[1]
+	for _, user := range positional {
+		if config.IsReserved(user) {
+			fatal(fmt.Sprintf("Error: '%s' is reserved for comments and settings, not a user ID", user))
+		}
+	}
+	configPath := commandConfigPath(configFlag)
+	if _, err := os.Stat(configPath); err != nil {
+		failWith(exitConfigMissing, []string{"config", configPath}, fmt.Sprintf("Error: config file not found: %s", configPath))
+	}
+
+	var renamed string
+	var replaced, defaultMoved bool
+	notFound := false
+	err = updateConfigFile(configPath, false, func(raw, reserved map[string]json.RawMessage) error {
+		key, ok := findKey(raw, oldUser)
+		if !ok {
+			notFound = true
+			return fmt.Errorf("user '%s' not found in config file at %s", oldUser, configPath)
+		}
+		if key == newUser {
+			return fmt.Errorf("user '%s' is already called that", key)
+		}
+		// A change of case only is a rename of the same user
+		if existing, ok := findKey(raw, newUser); ok && existing != key {
+			if !force {
+				return fmt.Errorf("user '%s' already exists (use --force to replace it)", existing)
+			}
+			delete(raw, existing)
+			replaced = true
+		}
+		raw[newUser] = raw[key]
+		delete(raw, key)
+		renamed = key
+
+		var defaultUser string
+		if err := json.Unmarshal(reserved[config.DefaultKey], &defaultUser); err == nil && strings.EqualFold(defaultUser, key) {
+			if reserved[config.DefaultKey], err = config.Marshal(newUser, ""); err != nil {
+				return err
+			}
+			defaultMoved = true
+		}
+		return nil
+	})
+	if notFound {
+		failWith(exitUserNotFound, []string{"user", oldUser, "config", configPath}, fmt.Sprintf("Error: %v", err))
+	}
+	if err != nil {
+		fatal(fmt.Sprintf("Error: %v", err))
+	}
+	if replaced {
+		fmt.Printf("🗑️ Replaced the existing '%s'\n", newUser)
+	}
+	fmt.Printf("✏️ Renamed '%s' to '%s' in %s\n", renamed, newUser, configPath)
+	if defaultMoved {
+		fmt.Printf("⭐ '%s' is still the default user\n", newUser)
+	}
+}
+
+// runQR prints an otpauth:// URI for a user as a QR code, for enrolling the
+// same account on another device
+func runQR(args []string) {
+	var configFlag string
+	var uriOnly bool
+	positional, err := parseCommandArgs(args,
+		map[string]*bool{"--uri-only": &uriOnly},
+		map[string]*string{"--config": &configFlag})
+	if err != nil {
+		fatal(fmt.Sprintf("Error: %v", err))
+	}
+	if len(positional) != 1 {
+		fatal("Usage: qr <user_id> [--uri-only] [--config <path>]")
+	}
+	configPath, explicit, err := resolveConfigPath(configFlag)
+	if err != nil {
+		fatal(fmt.Sprintf("Error: %v", err))
+	}
+	cfg, err := loadConfig(configPath, explicit)
+	if err != nil {
+		failWith(configExitCode(err), []string{"config", configPath}, fmt.Sprintf("Error: %v", err))
+	}
+
+	caseInsensitiveConfig, collisions := createCaseInsensitiveMap(cfg)
+	reportCaseCollisions(collisions, false)
+	userID, entry := lookupUser(cfg, caseInsensitiveConfig, configPath, positional[0])
+	label := userID
+	if entry.Label != "" {
+		label = entry.Label
+	}
+	uri := entry.OTPAuthURI(label)
+	if uriOnly {
+		fmt.Println(uri)
+		return
+	}
+
+	qr, err := encodeQR(uri)
+	if err != nil {
+		fatal(fmt.Sprintf("Error: %v", err))
+	}
+	fmt.Print(renderQR(qr))
+	fmt.Printf("👤 Scan to enroll '%s' on another device\n", userID)
+}
+
+// verifyOutput is the JSON form of a verify result
+type verifyOutput struct {
+	User  string `json:"user"`
+	Valid bool   `json:"valid"`
+	// Offset is the matching time step relative to the current one, or the
+	// counter ahead of the stored one for HOTP
+	Offset *int64 `json:"offset,omitempty"`
+	// SkewSeconds is how far the code's clock seems to be from this one, for
+	// TOTP codes that matched away from the current step, or would have with
+	// a wider window
+	SkewSeconds *int64 `json:"skew_seconds,omitempty"`
+	// Rotation names the secret that matched, for users with a list of them
+	Rotation string `json:"rotation,omitempty"`
+}
+
+// skewSearchSteps is how many time steps either side of now a code that
+// didn't verify is looked up in, to diagnose clock skew
+const skewSearchSteps = 10
+
+// maxVerifyWindow caps --window. Each step is another code to compute and
+// another a guesser gets to hit, and no server accepts anything near it.
+const maxVerifyWindow = 100
+
+// runVerify checks a code someone gave against a user's secret the way a
+// server would, accepting codes from a few time steps either side of now.
+// HOTP codes are looked for ahead of the stored counter, which is left alone.
+func runVerify(args []string) {
+	var configFlag string
+	windowFlag := "1"
+	positional, err := parseCommandArgs(args, nil, map[string]*string{
+		"--config": &configFlag,
+		"--window": &windowFlag,
+	})
+	if err != nil {
+		fatal(fmt.Sprintf("Error: %v", err))
+	}
+	if len(positional) != 2 {
+		fatal("Usage: verify <user_id> <code> [--window <steps>] [--config <path>]")
+	}
+	window, err := strconv.ParseInt(windowFlag, 10, 64)
+	if err != nil || window < 0 || window > maxVerifyWindow {
+		fatal(fmt.Sprintf("Invalid --window value '%s': must be a number of time steps from 0 to %d", windowFlag, maxVerifyWindow))
+	}
+	configPath, explicit, err := resolveConfigPath(configFlag)
+	if err != nil {
+		fatal(fmt.Sprintf("Error: %v", err))
+	}
+	cfg, err := loadConfig(configPath, explicit)
+	if err != nil {
+		failWith(configExitCode(err), []string{"config", configPath}, fmt.Sprintf("Error: %v", err))
+	}
+
+	caseInsensitiveConfig, collisions := createCaseInsensitiveMap(cfg)
+	reportCaseCollisions(collisions, false)
+	userID, entry := lookupUser(cfg, caseInsensitiveConfig, configPath, positional[0])
+	given := strings.ReplaceAll(strings.TrimSpace(positional[1]), " ", "")
+	if entry.IsSteam() {
+		given = strings.ToUpper(given)
+	}
+
+	// During a secret rotation a code from any of the secrets is valid
+	var offset int64
+	var matched bool
+	for _, rotated := range entry.Rotated() {
+		if offset, matched, err = matchCode(rotated, given, window); err != nil {
+			failWith(exitInvalidSecret, []string{"user", userID}, fmt.Sprintf("Error generating code: %v", err))
+		}
+		if matched {
+			entry = rotated
+			break
+		}
+	}
+
+	// A TOTP code that only matches away from the current step hints at a
+	// clock being off, so say by how much
+	skewOffset, skewed := offset, matched && offset != 0
+	if !matched && window < skewSearchSteps {
+		skewOffset, skewed, _ = matchCode(entry, given, skewSearchSteps)
+	}
+	skewed = skewed && !entry.IsHOTP()
+	skew := skewOffset * int64(entry.Options().Period)
+
+	if jsonOutput {
+		out := verifyOutput{User: userID, Valid: matched, Rotation: entry.Rotation}
+		if matched {
+			out.Offset = &offset
+		}
+		if skewed {
+			out.SkewSeconds = &skew
+		}
+		printJSON(os.Stdout, out)
+	} else if matched && entry.Rotation != "" {
+		fmt.Printf("✅ Code is valid for '%s' (%s, %s secret)\n", userID, describeOffset(entry, offset), entry.Rotation)
+	} else if matched {
+		fmt.Printf("✅ Code is valid for '%s' (%s)\n", userID, describeOffset(entry, offset))
+	} else {
+		fmt.Printf("❌ Code is not valid for '%s' within %d step(s)\n", userID, window)
+	}
+	if skewed && !jsonOutput {
+		fmt.Println(describeSkew(skew, matched))
+	}
+	if !matched {
+		os.Exit(exitNoMatch)
+	}
+}
+
+// matchCode looks for the time step, or HOTP counter, within window where the
+// entry produces the given code. Codes are compared in constant time, as a
+// server should.
+func matchCode(entry config.Entry, given string, window int64) (int64, bool, error) {
+	if entry.IsHOTP() {
+		for ahead := int64(0); ahead <= window; ahead++ {
+			if *entry.Counter > math.MaxUint64-uint64(ahead) {
+				// There are no counters past the last one
+				break
+			}
+			counter := *entry.Counter + uint64(ahead)
+			candidate := entry
+			candidate.Counter = &counter
+			code, err := candidate.Code(time.Now())
+			if err != nil {
+				return 0, false, err
+			}
+			if subtle.ConstantTimeCompare([]byte(code), []byte(given)) == 1 {
+				return ahead, true, nil
+			}
+		}
+		return 0, false, nil
+	}
+
+	for _, offset := range verifyOrder(window) {
+		code, err := entryCode(entry, offset)
+		if err != nil {
+			return 0, false, err
+		}
+		if subtle.ConstantTimeCompare([]byte(code), []byte(given)) == 1 {
+			return offset, true, nil
+		}
+	}
+	return 0, false, nil
+}
+
+// verifyOrder lists the time step offsets to try, nearest to now first
+func verifyOrder(window int64) []int64 {
+	offsets := []int64{0}
+	for i := int64(1); i <= window; i++ {
+		offsets = append(offsets, -i, i)
+	}
+	return offsets
+}
+
+// describeSkew explains the clock skew implied by a code matching skew
+// seconds away from now. Codes from the future mean this clock is behind.
+func describeSkew(skew int64, matched bool) string {
+	verb := "Matched"
+	if !matched {
+		verb = "Would match"
+	}
+	direction := "behind"
+	if skew < 0 {
+		direction = "ahead"
+	}
+	return fmt.Sprintf("🕒 %s at %+ds, your clock may be %s (or the other device's clock is off)", verb, skew, direction)
+}
+
+// describeOffset explains where a verified code matched
+func describeOffset(entry config.Entry, offset int64) string {
+	switch {
+	case entry.IsHOTP() && offset == 0:
+		return "current counter"
+	case entry.IsHOTP():
+		return fmt.Sprintf("counter +%d", offset)
+	case offset == 0:
+		return "current time step"
+	case offset < 0:
+		return fmt.Sprintf("%d step(s) ago", -offset)
+	default:
+		return fmt.Sprintf("%d step(s) ahead", offset)
+	}
+}
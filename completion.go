@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/nsvirk/totp-cli/config"
+)
+
+// completeCommand is the hidden argument the completion scripts call to list
+// user IDs matching the word being completed
+const completeCommand = "__complete"
+
+// completionFlags are the options offered when completing a word starting with "-"
+var completionFlags = []string{
+	"--help", "--version", "--no-copy", "--copy", "--quiet", "--copy-only", "--raw", "--no-newline", "--primary", "--osc52", "--group", "--cache", "--audit", "--clear-after", "--out", "--format", "--advance", "--list", "--check", "--all", "--codes",
+	"--watch", "--json", "--porcelain", "--interactive", "--explain", "--strict", "--config", "--time-offset", "--at", "--adjacent", "--wait", "--expiry-warning",
+	"--secret", "--stdin", "--algorithm", "--digits", "--period",
+	"--encrypt", "--decrypt",
+}
+
+const bashCompletion = `# bash completion for {{name}}
+# Load it with: source <({{name}} --completion bash)
+_{{func}}() {
+    local cur prev i
+    local -a config
+    cur="${COMP_WORDS[COMP_CWORD]}"
+    prev="${COMP_WORDS[COMP_CWORD-1]}"
+    case "$prev" in
+        --config|--out) COMPREPLY=($(compgen -f -- "$cur")); return ;;
+        --time-offset|--clear-after|--at|--format|--advance|--expiry-warning|--secret|--algorithm|--digits|--period) return ;;
+    esac
+    if [[ "$cur" == -* ]]; then
+        COMPREPLY=($(compgen -W "{{flags}}" -- "$cur"))
+        return
+    fi
+    for ((i = 1; i < COMP_CWORD; i++)); do
+        if [[ "${COMP_WORDS[i]}" == --config ]]; then
+            config=(--config "${COMP_WORDS[i+1]}")
+        fi
+    done
+    COMPREPLY=($({{name}} __complete "${config[@]}" "$cur" 2>/dev/null))
+    if ((COMP_CWORD == 1)); then
+        COMPREPLY+=($(compgen -W "{{commands}}" -- "$cur"))
+    fi
+}
+complete -F _{{func}} {{name}}
+`
+
+const zshCompletion = `#compdef {{name}}
+# zsh completion for {{name}}
+# Load it with: source <({{name}} --completion zsh)
+_{{func}}() {
+    local i
+    local -a config users
+    case "${words[CURRENT-1]}" in
+        --config|--out) _files; return ;;
+        --time-offset|--clear-after|--at|--format|--advance|--expiry-warning|--secret|--algorithm|--digits|--period) return ;;
+    esac
+    if [[ "${words[CURRENT]}" == -* ]]; then
+        compadd -- {{flags}}
+        return
+    fi
+    for ((i = 2; i < CURRENT; i++)); do
+        if [[ "${words[i]}" == --config ]]; then
+            config=(--config "${words[i+1]}")
+        fi
+    done
+    users=(${(f)"$({{name}} __complete "${config[@]}" "${words[CURRENT]}" 2>/dev/null)"})
+    compadd -a users
+    if ((CURRENT == 2)); then
+        compadd -- {{commands}}
+    fi
+}
+compdef _{{func}} {{name}}
+`
+
+// printCompletion writes the completion script for shell to stdout, using
+// the name the program was run as so it also works when renamed
+func printCompletion(shell string) error {
+	var script string
+	switch shell {
+	case "bash":
+		script = bashCompletion
+	case "zsh":
+		script = zshCompletion
+	default:
+		return fmt.Errorf("unsupported shell '%s' (expected bash or zsh)", shell)
+	}
+
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	name := filepath.Base(os.Args[0])
+	replacer := strings.NewReplacer(
+		"{{name}}", name,
+		"{{func}}", strings.NewReplacer("-", "_", ".", "_").Replace(name),
+		"{{flags}}", strings.Join(completionFlags, " "),
+		"{{commands}}", strings.Join(names, " "),
+	)
+	fmt.Print(replacer.Replace(script))
+	return nil
+}
+
+// runComplete prints the user IDs starting with the last argument, ignoring
+// case. It never prompts: an encrypted config is skipped unless the
+// passphrase is in the environment, and errors just produce no matches.
+func runComplete(args []string) {
+	var configFlag, prefix string
+	for i := 0; i < len(args); i++ {
+		if args[i] == "--config" && i+1 < len(args) {
+			i++
+			configFlag = args[i]
+			continue
+		}
+		prefix = args[i]
+	}
+
+	configPath, explicit, err := resolveConfigPath(configFlag)
+	if err != nil {
+		return
+	}
+	if data, err := os.ReadFile(configPath); err == nil && config.IsEncrypted(data) && os.Getenv(passphraseEnvVar) == "" {
+		// Only complete with a passphrase that needs no prompt
+		passphrase, ok := agentPassphrase(configPath)
+		if !ok {
+			return
+		}
+		cachedPassphrases[canonicalPath(configPath)] = passphrase
+	}
+	cfg, err := loadConfig(configPath, explicit)
+	if err != nil {
+		return
+	}
+
+	prefix = strings.ToLower(prefix)
+	for _, user := range sortedUsers(cfg) {
+		if strings.HasPrefix(strings.ToLower(user), prefix) {
+			fmt.Println(user)
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package config
+
+import "testing"
+
+func TestHasComments(t *testing.T) {
+	for _, tt := range []struct {
+		format Format
+		data   string
+		want   bool
+	}{
+		{FormatYAML, "# accounts\ngithub: JBSWY3DPEHPK3PXP\n", true},
+		{FormatYAML, "github: JBSWY3DPEHPK3PXP # work\n", true},
+		{FormatYAML, "work:\n  # team\n  github: JBSWY3DPEHPK3PXP\n", true},
+		{FormatYAML, "github: \"JBSWY3DPEHPK3PXP # not a comment\"\n", false},
+		{FormatYAML, "github: otpauth://totp/a?secret=JBSWY3DPEHPK3PXP#frag\n", false},
+		{FormatYAML, "_comment: kept\ngithub: JBSWY3DPEHPK3PXP\n", false},
+		{FormatTOML, "# accounts\ngithub = \"JBSWY3DPEHPK3PXP\"\n", true},
+		{FormatTOML, "[work] # team\ngithub = \"JBSWY3DPEHPK3PXP\"\n", true},
+		{FormatTOML, "github = \"JBSWY3DPEHPK3PXP\" # work\n", true},
+		{FormatTOML, "github = \"JBSWY3DPEHPK3PXP # not a comment\"\n", false},
+		{FormatJSON, "{\"github\": \"JBSWY3DPEHPK3PXP # not a comment\"}", false},
+	} {
+		if got := HasComments([]byte(tt.data), tt.format); got != tt.want {
+			t.Errorf("HasComments(%s, %q) = %v, want %v", tt.format, tt.data, got, tt.want)
+		}
+	}
+}
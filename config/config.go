@@ -0,0 +1,195 @@
+// Package config reads the totp-cli config format: a JSON, YAML or TOML object
+// mapping user IDs to secrets, otpauth:// URIs or objects with per-user
+// settings, optionally nested into groups and encrypted with a passphrase.
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Config maps user IDs to their entries
+type Config map[string]Entry
+
+// Errors callers may want to tell apart, e.g. for exit codes
+var (
+	ErrNotFound     = errors.New("config file not found")
+	ErrInvalidEntry = errors.New("invalid entry")
+	ErrEmpty        = errors.New("no users configured")
+)
+
+// Format is the syntax a config file is written in
+type Format string
+
+// Supported config file formats
+const (
+	FormatJSON Format = "JSON"
+	FormatYAML Format = "YAML"
+	FormatTOML Format = "TOML"
+)
+
+// FormatOf picks a config file's format from its extension, defaulting to JSON
+func FormatOf(path string) Format {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return FormatYAML
+	case ".toml":
+		return FormatTOML
+	default:
+		return FormatJSON
+	}
+}
+
+// Load reads and parses the config file at path. The passphrase is only
+// used if the file is encrypted.
+func Load(path, passphrase string) (Config, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if IsEncrypted(data) {
+		if data, err = Decrypt(data, passphrase); err != nil {
+			return nil, err
+		}
+	}
+	return Parse(data, FormatOf(path))
+}
+
+// Parse parses a config document into entries, with grouped users flattened
+// to "group/name" user IDs
+func Parse(data []byte, format Format) (Config, error) {
+	raw, err := ParseRaw(data, format)
+	if err != nil {
+		return nil, err
+	}
+	return ParseEntries(raw)
+}
+
+// ParseRaw parses a config document into a map of unparsed entries, with
+// grouped users flattened to "group/name" keys, and comments and settings
+// like DefaultKey left out
+func ParseRaw(data []byte, format Format) (map[string]json.RawMessage, error) {
+	raw, _, err := ParseRawSettings(data, format)
+	return raw, err
+}
+
+// ParseRawSettings is ParseRaw that also returns the comments and settings
+// it leaves out, so a file is decoded once for both
+func ParseRawSettings(data []byte, format Format) (raw, reserved map[string]json.RawMessage, err error) {
+	decoded, err := Decode(data, format)
+	if err != nil {
+		return nil, nil, err
+	}
+	flat, _, err := Flatten(decoded)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid config file: %v", err)
+	}
+	return flat, StripReserved(flat), nil
+}
+
+// ParseEntries parses every value of a raw config, failing with an error
+// wrapping ErrInvalidEntry on the first one that's broken
+func ParseEntries(raw map[string]json.RawMessage) (Config, error) {
+	config := make(Config, len(raw))
+	for user, value := range raw {
+		entry, err := ParseEntry(value)
+		if err != nil {
+			return nil, fmt.Errorf("%w for user '%s': %v", ErrInvalidEntry, user, err)
+		}
+		config[user] = entry
+	}
+	return config, nil
+}
+
+// Decode parses a config document into its top-level values as JSON,
+// leaving groups nested. Whatever the format, entries come out in the same
+// form a JSON config would give.
+func Decode(data []byte, format Format) (map[string]json.RawMessage, error) {
+	// A blank file is an empty config in any format, e.g. one just created
+	if len(bytes.TrimSpace(data)) == 0 {
+		return map[string]json.RawMessage{}, nil
+	}
+	var raw map[string]json.RawMessage
+	var mapping map[string]any
+	switch format {
+	case FormatYAML:
+		doc, err := decodeYAML(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid YAML in config file: %v", err)
+		}
+		var ok bool
+		if mapping, ok = doc.(map[string]any); !ok {
+			return nil, fmt.Errorf("invalid YAML in config file: expected a mapping of user IDs")
+		}
+	case FormatTOML:
+		var err error
+		if mapping, err = decodeTOML(data); err != nil {
+			return nil, fmt.Errorf("invalid TOML in config file: %v", err)
+		}
+	default:
+		if err := json.Unmarshal(data, &raw); err != nil {
+			return nil, fmt.Errorf("invalid JSON in config file: %v", err)
+		}
+		return raw, nil
+	}
+
+	var err error
+	raw = make(map[string]json.RawMessage, len(mapping))
+	for key, value := range mapping {
+		if raw[key], err = Marshal(value, ""); err != nil {
+			return nil, err
+		}
+	}
+	return raw, nil
+}
+
+// HasComments reports whether a YAML or TOML document has # comments, which
+// Encode can't write back. JSON has none, only _comment keys, which are
+// ordinary values.
+func HasComments(data []byte, format Format) bool {
+	switch format {
+	case FormatYAML:
+		return yamlHasComments(data)
+	case FormatTOML:
+		return tomlHasComments(data)
+	}
+	return false
+}
+
+// Encode writes a config, as nested by Nest, in the given format with
+// sorted keys. Comments in YAML and TOML files aren't preserved, see
+// HasComments.
+func Encode(v map[string]any, format Format) ([]byte, error) {
+	switch format {
+	case FormatYAML:
+		return encodeYAML(v)
+	case FormatTOML:
+		return encodeTOML(v)
+	}
+	out, err := Marshal(v, "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(out, '\n'), nil
+}
+
+// Marshal encodes a value as JSON without escaping HTML characters, so
+// otpauth URIs keep their literal '&' separators
+func Marshal(v any, indent string) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", indent)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(buf.Bytes(), "\n"), nil
+}
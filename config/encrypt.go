@@ -0,0 +1,92 @@
+package config
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+)
+
+// Encrypted config files start with encryptedMagic, followed by the PBKDF2
+// salt, the AES-GCM nonce, and the sealed config JSON
+const (
+	encryptedMagic   = "TOTPENC1"
+	saltSize         = 16
+	keySize          = 32
+	pbkdf2Iterations = 600000
+)
+
+// IsEncrypted reports whether config file contents carry the encrypted header
+func IsEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(encryptedMagic))
+}
+
+// deriveKey stretches a passphrase into an AES-256 key
+func deriveKey(passphrase string, salt []byte) ([]byte, error) {
+	return pbkdf2.Key(sha256.New, passphrase, salt, pbkdf2Iterations, keySize)
+}
+
+// Encrypt seals plaintext config JSON with a key derived from passphrase
+func Encrypt(plaintext []byte, passphrase string) ([]byte, error) {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	key, err := deriveKey(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	out := append([]byte(encryptedMagic), salt...)
+	out = append(out, nonce...)
+	// The header is authenticated so it can't be tampered with
+	return gcm.Seal(out, nonce, plaintext, out), nil
+}
+
+// Decrypt opens an encrypted config file with the given passphrase
+func Decrypt(data []byte, passphrase string) ([]byte, error) {
+	header := len(encryptedMagic) + saltSize
+	if len(data) < header {
+		return nil, fmt.Errorf("encrypted config file is truncated")
+	}
+	salt := data[len(encryptedMagic):header]
+
+	key, err := deriveKey(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < header+gcm.NonceSize() {
+		return nil, fmt.Errorf("encrypted config file is truncated")
+	}
+	nonce := data[header : header+gcm.NonceSize()]
+	sealed := data[header+gcm.NonceSize():]
+
+	plaintext, err := gcm.Open(nil, nonce, sealed, data[:header+gcm.NonceSize()])
+	if err != nil {
+		return nil, fmt.Errorf("wrong passphrase or corrupted config file")
+	}
+	return plaintext, nil
+}
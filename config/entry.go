@@ -0,0 +1,491 @@
+package config
+
+import (
+	"encoding/base32"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nsvirk/totp-cli/totp"
+)
+
+// SteamType is the entry type for Steam Guard codes
+const SteamType = "steam"
+
+// Entry represents the TOTP settings for a single user. Entries with a
+// counter generate counter-based HOTP codes instead of time-based ones, and
+// entries of type "steam" generate Steam Guard codes. Issuer and Label only
+// change how the entry is displayed.
+//
+// During a secret rotation an entry can hold a list of secrets, oldest
+// first, in Secrets. Secret is then the newest one, so everything that only
+// needs one secret uses the one that's staying.
+type Entry struct {
+	Secret    string  `json:"secret"`
+	Algorithm string  `json:"algorithm,omitempty"`
+	Digits    int     `json:"digits,omitempty"`
+	Period    int     `json:"period,omitempty"`
+	Counter   *uint64 `json:"counter,omitempty"`
+	Type      string  `json:"type,omitempty"`
+	Encoding  string  `json:"encoding,omitempty"`
+	Issuer    string  `json:"issuer,omitempty"`
+	Label     string  `json:"label,omitempty"`
+
+	// Secrets lists every secret of a rotation, oldest first, and is nil
+	// for the usual single secret
+	Secrets []string `json:"-"`
+	// Rotation labels the entries returned by Rotated, e.g. "old" or "new"
+	Rotation string `json:"-"`
+	// FromURI is set when the secret was an otpauth:// URI, whose settings
+	// are complete: those it leaves out have the URI format's defaults
+	FromURI bool `json:"-"`
+
+	// keys caches the decoded secret for entries from ParseEntry, see
+	// sharedKeys
+	keys *totp.KeyCache
+}
+
+// sharedKeys is the key cache of every entry from ParseEntry. Sharing it
+// means each secret is decoded once per run, however many entries, windows
+// and checks use it: --check generating a code and then measuring the key,
+// --adjacent's three windows, the same entry parsed again before an HOTP
+// counter is saved, or one secret configured under several user IDs.
+var sharedKeys = &totp.KeyCache{}
+
+// UnmarshalJSON accepts a plain secret string, a list of secrets being
+// rotated, or an object with settings whose "secret" is either of those
+func (e *Entry) UnmarshalJSON(data []byte) error {
+	if secret, secrets, err := parseSecrets(data); err == nil {
+		*e = Entry{Secret: secret, Secrets: secrets}
+		return nil
+	} else if len(data) > 0 && data[0] == '[' {
+		return err
+	}
+
+	// Use an alias type to avoid recursing into this method. The outer
+	// Secret field takes the place of the alias's one.
+	type entry Entry
+	var v struct {
+		entry
+		Secret json.RawMessage `json:"secret"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return fmt.Errorf("expected a secret string or an object with a \"secret\" field")
+	}
+	*e = Entry(v.entry)
+	if len(v.Secret) > 0 && string(v.Secret) != "null" {
+		secret, secrets, err := parseSecrets(v.Secret)
+		if err != nil {
+			return err
+		}
+		e.Secret, e.Secrets = secret, secrets
+	}
+	return nil
+}
+
+// parseSecrets decodes a secret string or a list of them, returning the
+// newest (last) secret and, for a list of more than one, all of them
+func parseSecrets(data json.RawMessage) (string, []string, error) {
+	var secret string
+	if err := json.Unmarshal(data, &secret); err == nil {
+		return secret, nil, nil
+	}
+	var secrets []string
+	if err := json.Unmarshal(data, &secrets); err != nil {
+		return "", nil, fmt.Errorf("expected a secret string or a list of secret strings")
+	}
+	switch len(secrets) {
+	case 0:
+		return "", nil, fmt.Errorf("empty list of secrets")
+	case 1:
+		return secrets[0], nil, nil
+	}
+	return secrets[len(secrets)-1], secrets, nil
+}
+
+// IsRotating reports whether the entry holds more than one secret
+func (e Entry) IsRotating() bool {
+	return len(e.Secrets) > 1
+}
+
+// Rotated returns one entry per secret, oldest first, labeled "old" and
+// "new" (or "old 1", "old 2"... with more than two). An entry with a single
+// secret is returned as is.
+func (e Entry) Rotated() []Entry {
+	if !e.IsRotating() {
+		return []Entry{e}
+	}
+	entries := make([]Entry, len(e.Secrets))
+	for i, secret := range e.Secrets {
+		entries[i] = e
+		entries[i].Secret, entries[i].Secrets = secret, nil
+		switch {
+		case i == len(e.Secrets)-1:
+			entries[i].Rotation = "new"
+		case len(e.Secrets) == 2:
+			entries[i].Rotation = "old"
+		default:
+			entries[i].Rotation = "old " + strconv.Itoa(i+1)
+		}
+	}
+	return entries
+}
+
+// Options returns the entry's code parameters, with defaults filled in for
+// any that aren't configured
+func (e Entry) Options() totp.Options {
+	opts := totp.Options{
+		Digits:    e.Digits,
+		Period:    e.Period,
+		Algorithm: strings.ToUpper(e.Algorithm),
+		Encoding:  strings.ToLower(e.Encoding),
+		Keys:      e.keys,
+	}
+	if opts.Digits == 0 {
+		opts.Digits = totp.DefaultDigits
+	}
+	if opts.Period == 0 {
+		opts.Period = totp.DefaultPeriod
+	}
+	if opts.Algorithm == "" {
+		opts.Algorithm = totp.DefaultAlgorithm
+	}
+	return opts
+}
+
+// DisplayName returns the name to show for the entry of user: its issuer
+// and label when configured, like "GitHub (octocat)", or else the user ID
+func (e Entry) DisplayName(user string) string {
+	switch {
+	case e.Issuer != "" && e.Label != "":
+		return e.Issuer + " (" + e.Label + ")"
+	case e.Issuer != "":
+		return e.Issuer
+	case e.Label != "":
+		return e.Label
+	default:
+		return user
+	}
+}
+
+// IsHOTP reports whether the entry uses a counter instead of the current time
+func (e Entry) IsHOTP() bool {
+	return e.Counter != nil
+}
+
+// IsSteam reports whether the entry produces Steam Guard codes
+func (e Entry) IsSteam() bool {
+	return strings.EqualFold(e.Type, SteamType)
+}
+
+// Code generates the entry's code for time t, in its format. HOTP entries
+// use their current counter, which the caller is responsible for advancing.
+func (e Entry) Code(t time.Time) (string, error) {
+	opts := e.Options()
+	opts.Time = t
+	switch {
+	case e.IsHOTP():
+		return totp.GenerateHOTP(e.Secret, *e.Counter, opts)
+	case e.IsSteam():
+		return totp.GenerateSteam(e.Secret, opts)
+	default:
+		return totp.Generate(e.Secret, opts)
+	}
+}
+
+// Check generates a throwaway code to make sure the entry's secrets work,
+// without advancing HOTP counters
+func (e Entry) Check() error {
+	for _, rotated := range e.Rotated() {
+		if _, err := rotated.Code(time.Now()); err != nil {
+			if rotated.Rotation != "" {
+				return fmt.Errorf("%s secret: %v", rotated.Rotation, err)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// Validate checks the entry settings for values the code generators can't handle
+func (e Entry) Validate() error {
+	if e.Secret == "" {
+		return fmt.Errorf("missing secret")
+	}
+	opts := e.Options()
+	if _, err := totp.HashFunc(opts.Algorithm); err != nil {
+		return err
+	}
+	if opts.Encoding != "" && opts.Encoding != totp.EncodingBase32 && opts.Encoding != totp.EncodingBase32Hex && opts.Encoding != totp.EncodingHex {
+		return fmt.Errorf("unsupported encoding '%s' (must be %s, %s or %s)", e.Encoding, totp.EncodingBase32, totp.EncodingBase32Hex, totp.EncodingHex)
+	}
+	for _, secret := range e.Secrets {
+		if secret == "" {
+			return fmt.Errorf("empty secret in the list of secrets")
+		}
+	}
+	if e.IsRotating() && e.IsHOTP() {
+		return fmt.Errorf("HOTP entries can't have a list of secrets, their counters would drift apart")
+	}
+	if e.IsSteam() {
+		if e.IsHOTP() {
+			return fmt.Errorf("steam entries can't have a counter")
+		}
+	} else if e.Type != "" && !strings.EqualFold(e.Type, "totp") {
+		return fmt.Errorf("unsupported type '%s' (must be totp or steam)", e.Type)
+	} else if opts.Digits < totp.MinDigits || opts.Digits > totp.MaxDigits {
+		return fmt.Errorf("digits must be between %d and %d, got %d", totp.MinDigits, totp.MaxDigits, opts.Digits)
+	}
+	if e.Period < 0 {
+		return fmt.Errorf("period must be a positive number of seconds, got %d", e.Period)
+	}
+	return nil
+}
+
+// ParseEntry decodes a single config value, expanding otpauth:// URIs, and
+// validates its settings
+func ParseEntry(value json.RawMessage) (Entry, error) {
+	var entry Entry
+	if err := json.Unmarshal(value, &entry); err != nil {
+		return Entry{}, err
+	}
+	for _, secret := range entry.Secrets {
+		if strings.HasPrefix(secret, "otpauth://") {
+			return Entry{}, fmt.Errorf("a list of secrets can't contain otpauth URIs, give the bare secrets")
+		}
+	}
+	uri := entry.Secret
+	entry, err := entry.resolveURI()
+	if err != nil {
+		return Entry{}, fmt.Errorf("invalid otpauth URI %s: %v", redactURI(uri), err)
+	}
+	if err := entry.Validate(); err != nil {
+		return Entry{}, err
+	}
+	entry.keys = sharedKeys
+	return entry, nil
+}
+
+// uriParameters are the otpauth:// query parameters understood by
+// ParseOTPAuthURI. Anything else is rejected rather than silently ignored.
+var uriParameters = map[string]bool{
+	"secret": true, "issuer": true, "algorithm": true, "digits": true, "period": true, "counter": true,
+}
+
+// redactURI hides the secret of an otpauth:// URI, so it can be shown in
+// error messages
+func redactURI(uri string) string {
+	base, rawQuery, _ := strings.Cut(uri, "?")
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return base + "?..."
+	}
+	if query.Has("secret") {
+		query.Set("secret", "REDACTED")
+	}
+	return base + "?" + query.Encode()
+}
+
+// ParseOTPAuthURI parses an otpauth://totp/, otpauth://hotp/ or
+// otpauth://steam/ URI into an entry. Parameters are validated strictly:
+// unknown or repeated ones, and values that aren't valid settings, are
+// errors naming the parameter.
+func ParseOTPAuthURI(uri string) (Entry, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return Entry{}, err
+	}
+	if u.Scheme != "otpauth" {
+		return Entry{}, fmt.Errorf("unsupported scheme '%s'", u.Scheme)
+	}
+	if u.Host != "totp" && u.Host != "hotp" && u.Host != SteamType {
+		return Entry{}, fmt.Errorf("unsupported OTP type '%s' (must be totp, hotp or steam)", u.Host)
+	}
+
+	query, err := url.ParseQuery(u.RawQuery)
+	if err != nil {
+		return Entry{}, fmt.Errorf("invalid query: %v", err)
+	}
+	for name, values := range query {
+		if !uriParameters[name] {
+			return Entry{}, fmt.Errorf("unknown parameter '%s'", name)
+		}
+		if len(values) > 1 {
+			return Entry{}, fmt.Errorf("parameter '%s' given more than once", name)
+		}
+	}
+	entry := Entry{
+		Secret:    query.Get("secret"),
+		Algorithm: query.Get("algorithm"),
+	}
+	entry.Issuer, entry.Label = uriLabel(u.Path, query.Get("issuer"))
+	if entry.Secret == "" {
+		return Entry{}, fmt.Errorf("missing secret parameter")
+	}
+	if query.Has("algorithm") {
+		if _, err := totp.HashFunc(entry.Algorithm); err != nil {
+			return Entry{}, fmt.Errorf("invalid algorithm parameter '%s' (must be SHA1, SHA256, or SHA512)", entry.Algorithm)
+		}
+	}
+	if u.Host == SteamType {
+		entry.Type = SteamType
+	}
+	if query.Has("digits") {
+		v := query.Get("digits")
+		if entry.Digits, err = strconv.Atoi(v); err != nil || entry.Digits < totp.MinDigits || entry.Digits > totp.MaxDigits {
+			return Entry{}, fmt.Errorf("invalid digits parameter '%s' (must be a number between %d and %d)", v, totp.MinDigits, totp.MaxDigits)
+		}
+	}
+	if query.Has("period") {
+		v := query.Get("period")
+		if u.Host == "hotp" {
+			return Entry{}, fmt.Errorf("period parameter doesn't apply to hotp")
+		}
+		if entry.Period, err = strconv.Atoi(v); err != nil || entry.Period <= 0 {
+			return Entry{}, fmt.Errorf("invalid period parameter '%s' (must be a positive number of seconds)", v)
+		}
+	}
+	if query.Has("counter") && u.Host != "hotp" {
+		return Entry{}, fmt.Errorf("counter parameter only applies to hotp")
+	}
+	if u.Host == "hotp" {
+		v := query.Get("counter")
+		if v == "" {
+			return Entry{}, fmt.Errorf("missing counter parameter for hotp")
+		}
+		counter, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return Entry{}, fmt.Errorf("invalid counter parameter '%s'", v)
+		}
+		entry.Counter = &counter
+	}
+	return entry, nil
+}
+
+// uriLabel splits the label of an otpauth:// URI, "Issuer:account" or just
+// "account", into the issuer and account name. The issuer parameter wins
+// over the label's prefix, as the Key URI format says.
+func uriLabel(path, issuerParam string) (issuer, account string) {
+	label := strings.TrimPrefix(path, "/")
+	if prefix, name, ok := strings.Cut(label, ":"); ok {
+		issuer, label = strings.TrimSpace(prefix), name
+	}
+	if issuerParam != "" {
+		issuer = issuerParam
+	}
+	return issuer, strings.TrimSpace(label)
+}
+
+// OTPAuthIssuer returns the issuer an otpauth:// URI names: its issuer
+// parameter, or else the "Issuer:" prefix of its label, and "" if it has
+// neither
+func OTPAuthIssuer(uri string) (string, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "otpauth" {
+		return "", fmt.Errorf("unsupported scheme '%s'", u.Scheme)
+	}
+	issuer, _ := uriLabel(u.Path, u.Query().Get("issuer"))
+	return issuer, nil
+}
+
+// OTPAuthURI builds an otpauth:// URI for the entry, as understood by
+// authenticator apps. Settings are only included when they differ from the
+// defaults, and the issuer when it's set.
+func (e Entry) OTPAuthURI(label string) string {
+	opts := e.Options()
+	query := url.Values{}
+	query.Set("secret", e.base32Secret())
+	if opts.Algorithm != totp.DefaultAlgorithm {
+		query.Set("algorithm", opts.Algorithm)
+	}
+	if opts.Digits != totp.DefaultDigits && !e.IsSteam() {
+		query.Set("digits", strconv.Itoa(opts.Digits))
+	}
+	if e.Issuer != "" {
+		query.Set("issuer", e.Issuer)
+	}
+
+	otpType := "totp"
+	if e.IsSteam() {
+		otpType = SteamType
+	}
+	if e.IsHOTP() {
+		otpType = "hotp"
+		query.Set("counter", strconv.FormatUint(*e.Counter, 10))
+	} else if opts.Period != totp.DefaultPeriod {
+		query.Set("period", strconv.Itoa(opts.Period))
+	}
+
+	// Apps show "Issuer:account" labels best, and older ones ignore the
+	// issuer parameter
+	if e.Issuer != "" && !strings.HasPrefix(label, e.Issuer+":") {
+		label = e.Issuer + ":" + label
+	}
+	u := url.URL{Scheme: "otpauth", Host: otpType, Path: "/" + label, RawQuery: query.Encode()}
+	return u.String()
+}
+
+// Key returns the decoded bytes of the entry's secret. They may be shared
+// with other entries, so they mustn't be modified.
+func (e Entry) Key() ([]byte, error) {
+	if e.keys != nil {
+		return e.keys.DecodeKey(e.Secret, e.Encoding)
+	}
+	return totp.DecodeKey(e.Secret, e.Encoding)
+}
+
+// base32Secret returns the secret as unpadded base32, as in otpauth:// URIs,
+// re-encoding hex and base32hex secrets
+func (e Entry) base32Secret() string {
+	if strings.EqualFold(e.Encoding, totp.EncodingHex) || strings.EqualFold(e.Encoding, totp.EncodingBase32Hex) {
+		if key, err := e.Key(); err == nil {
+			return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(key)
+		}
+	}
+	return strings.TrimRight(totp.NormalizeSecret(e.Secret), "=")
+}
+
+// resolveURI replaces an otpauth:// secret with the parameters it encodes.
+// Settings given explicitly alongside the URI take precedence.
+func (e Entry) resolveURI() (Entry, error) {
+	if !strings.HasPrefix(e.Secret, "otpauth://") {
+		return e, nil
+	}
+
+	if strings.EqualFold(e.Encoding, totp.EncodingHex) || strings.EqualFold(e.Encoding, totp.EncodingBase32Hex) {
+		return Entry{}, fmt.Errorf("otpauth URIs always have base32 secrets, remove the %s encoding", strings.ToLower(e.Encoding))
+	}
+	parsed, err := ParseOTPAuthURI(e.Secret)
+	if err != nil {
+		return Entry{}, err
+	}
+	if e.Algorithm != "" {
+		parsed.Algorithm = e.Algorithm
+	}
+	if e.Digits != 0 {
+		parsed.Digits = e.Digits
+	}
+	if e.Period != 0 {
+		parsed.Period = e.Period
+	}
+	if e.Counter != nil {
+		parsed.Counter = e.Counter
+	}
+	if e.Type != "" {
+		parsed.Type = e.Type
+	}
+	if e.Issuer != "" {
+		parsed.Issuer = e.Issuer
+	}
+	if e.Label != "" {
+		parsed.Label = e.Label
+	}
+	parsed.FromURI = true
+	return parsed, nil
+}
@@ -0,0 +1,101 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// GroupSeparator joins group names and user IDs, so {"work": {"github": ...}}
+// defines the user "work/github"
+const GroupSeparator = "/"
+
+// IsGroup reports whether a config value is a group of users rather than an
+// entry: any object without a "secret" field
+func IsGroup(value json.RawMessage) bool {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(value, &fields); err != nil {
+		return false
+	}
+	for field := range fields {
+		if strings.EqualFold(field, "secret") {
+			return false
+		}
+	}
+	return true
+}
+
+// Flatten turns nested groups into "group/name" user IDs. It also returns
+// the paths of the groups it found, keyed by their lowercased path, so Nest
+// can put the users back where they came from.
+func Flatten(raw map[string]json.RawMessage) (map[string]json.RawMessage, map[string]string, error) {
+	flat := make(map[string]json.RawMessage, len(raw))
+	groups := make(map[string]string)
+
+	var walk func(prefix string, raw map[string]json.RawMessage) error
+	walk = func(prefix string, raw map[string]json.RawMessage) error {
+		for key, value := range raw {
+			path := prefix + key
+			if IsGroup(value) && !IsReserved(key) {
+				var members map[string]json.RawMessage
+				if err := json.Unmarshal(value, &members); err != nil {
+					return err
+				}
+				groups[strings.ToLower(path)] = path
+				if err := walk(path+GroupSeparator, members); err != nil {
+					return err
+				}
+				continue
+			}
+			if _, exists := flat[path]; exists {
+				return fmt.Errorf("user '%s' is defined more than once", path)
+			}
+			flat[path] = value
+		}
+		return nil
+	}
+	if err := walk("", raw); err != nil {
+		return nil, nil, err
+	}
+	return flat, groups, nil
+}
+
+// Nest reverses Flatten, moving users whose IDs start with a known group
+// path back into that group. Other IDs containing a separator stay flat,
+// which reads back the same. Groups left without users are dropped.
+func Nest(flat map[string]json.RawMessage, groups map[string]string) (map[string]any, error) {
+	for key := range flat {
+		if group, ok := groups[strings.ToLower(key)]; ok {
+			return nil, fmt.Errorf("'%s' is already a group", group)
+		}
+	}
+
+	root := make(map[string]any, len(flat))
+	for key, value := range flat {
+		target, prefix, rest := root, "", key
+		for {
+			i := strings.Index(rest, GroupSeparator)
+			if i < 0 {
+				break
+			}
+			group, ok := groups[strings.ToLower(prefix+rest[:i])]
+			if !ok {
+				break
+			}
+			name := group[len(prefix):]
+			child, ok := target[name].(map[string]any)
+			if !ok {
+				child = make(map[string]any)
+				target[name] = child
+			}
+			target, prefix, rest = child, group+GroupSeparator, rest[i+1:]
+		}
+		target[rest] = value
+	}
+	return root, nil
+}
+
+// LeafName returns the last part of a grouped user ID, e.g. "github" for "work/github"
+func LeafName(user string) string {
+	return user[strings.LastIndex(user, GroupSeparator)+1:]
+}
@@ -0,0 +1,68 @@
+package config
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/nsvirk/totp-cli/totp"
+)
+
+// benchmarkConfig returns a config file with n users, each with its own
+// random 160-bit secret
+func benchmarkConfig(b *testing.B, n int) []byte {
+	raw := make(map[string]string, n)
+	for i := range n {
+		key := make([]byte, 20)
+		if _, err := rand.Read(key); err != nil {
+			b.Fatal(err)
+		}
+		raw[fmt.Sprintf("user_%03d", i)] = base32.StdEncoding.EncodeToString(key)
+	}
+	data, err := json.Marshal(raw)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return data
+}
+
+// BenchmarkCheckAndAll500 does what --check and --all do for a 500-entry
+// config in one run: parse it, check every entry and its key length, and
+// generate every current code. Each iteration is a fresh run with an empty
+// cache. "uncached" leaves the cache out to show what it saves.
+func BenchmarkCheckAndAll500(b *testing.B) {
+	data := benchmarkConfig(b, 500)
+	now := time.Now()
+	for _, cached := range []bool{true, false} {
+		name := "cached"
+		if !cached {
+			name = "uncached"
+		}
+		b.Run(name, func(b *testing.B) {
+			for b.Loop() {
+				sharedKeys = &totp.KeyCache{}
+				cfg, err := Parse(data, FormatJSON)
+				if err != nil {
+					b.Fatal(err)
+				}
+				for _, entry := range cfg {
+					if !cached {
+						entry.keys = nil
+					}
+					if err := entry.Check(); err != nil {
+						b.Fatal(err)
+					}
+					if _, err := entry.Key(); err != nil {
+						b.Fatal(err)
+					}
+					if _, err := entry.Code(now); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package config
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestParseOTPAuthURIMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		uri  string
+		want string
+	}{
+		{"scheme", "https://totp/x?secret=JBSWY3DPEHPK3PXP", "unsupported scheme 'https'"},
+		{"type", "otpauth://motp/x?secret=JBSWY3DPEHPK3PXP", "unsupported OTP type 'motp' (must be totp, hotp or steam)"},
+		{"query", "otpauth://totp/x?secret=JBSWY3DPEHPK3PXP&digits=%zz", "invalid query"},
+		{"unknown parameter", "otpauth://totp/x?secret=JBSWY3DPEHPK3PXP&foo=bar", "unknown parameter 'foo'"},
+		{"repeated parameter", "otpauth://totp/x?secret=JBSWY3DPEHPK3PXP&digits=6&digits=8", "parameter 'digits' given more than once"},
+		{"missing secret", "otpauth://totp/x?digits=6", "missing secret parameter"},
+		{"empty secret", "otpauth://totp/x?secret=", "missing secret parameter"},
+		{"algorithm", "otpauth://totp/x?secret=JBSWY3DPEHPK3PXP&algorithm=MD5", "invalid algorithm parameter 'MD5' (must be SHA1, SHA256, or SHA512)"},
+		{"non-numeric digits", "otpauth://totp/x?secret=JBSWY3DPEHPK3PXP&digits=six", "invalid digits parameter 'six'"},
+		{"digits out of range", "otpauth://totp/x?secret=JBSWY3DPEHPK3PXP&digits=10", "invalid digits parameter '10'"},
+		{"non-numeric period", "otpauth://totp/x?secret=JBSWY3DPEHPK3PXP&period=30s", "invalid period parameter '30s'"},
+		{"zero period", "otpauth://totp/x?secret=JBSWY3DPEHPK3PXP&period=0", "invalid period parameter '0'"},
+		{"period for hotp", "otpauth://hotp/x?secret=JBSWY3DPEHPK3PXP&counter=0&period=30", "period parameter doesn't apply to hotp"},
+		{"counter for totp", "otpauth://totp/x?secret=JBSWY3DPEHPK3PXP&counter=1", "counter parameter only applies to hotp"},
+		{"missing counter", "otpauth://hotp/x?secret=JBSWY3DPEHPK3PXP", "missing counter parameter for hotp"},
+		{"negative counter", "otpauth://hotp/x?secret=JBSWY3DPEHPK3PXP&counter=-1", "invalid counter parameter '-1'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseOTPAuthURI(tt.uri)
+			if err == nil {
+				t.Fatalf("ParseOTPAuthURI(%s) = nil error, want %q", tt.uri, tt.want)
+			}
+			if !strings.HasPrefix(err.Error(), tt.want) {
+				t.Errorf("ParseOTPAuthURI(%s) error = %q, want %q", tt.uri, err, tt.want)
+			}
+		})
+	}
+}
+
+// ParseEntry names the URI in its errors, but never the secret
+func TestParseEntryRedactsMalformedURI(t *testing.T) {
+	value, _ := json.Marshal("otpauth://totp/x?secret=JBSWY3DPEHPK3PXP&digits=nine")
+	_, err := ParseEntry(value)
+	if err == nil {
+		t.Fatal("ParseEntry accepted digits=nine")
+	}
+	if !strings.Contains(err.Error(), "otpauth://totp/x?") || !strings.Contains(err.Error(), "invalid digits parameter 'nine'") {
+		t.Errorf("ParseEntry error = %q, want the URI and the bad parameter", err)
+	}
+	if strings.Contains(err.Error(), "JBSWY3DPEHPK3PXP") {
+		t.Errorf("ParseEntry error = %q, leaks the secret", err)
+	}
+}
+
+func TestParseOTPAuthURILabel(t *testing.T) {
+	tests := []struct {
+		uri    string
+		issuer string
+		label  string
+	}{
+		{"otpauth://totp/GitHub:octocat?secret=JBSWY3DPEHPK3PXP&issuer=GitHub", "GitHub", "octocat"},
+		{"otpauth://totp/GitHub:octocat?secret=JBSWY3DPEHPK3PXP", "GitHub", "octocat"},
+		{"otpauth://totp/octocat?secret=JBSWY3DPEHPK3PXP&issuer=GitHub", "GitHub", "octocat"},
+		{"otpauth://totp/Old%20Name:%20octocat?secret=JBSWY3DPEHPK3PXP&issuer=GitHub", "GitHub", "octocat"},
+		{"otpauth://totp/alice%40example.com?secret=JBSWY3DPEHPK3PXP", "", "alice@example.com"},
+		{"otpauth://totp/?secret=JBSWY3DPEHPK3PXP", "", ""},
+	}
+	for _, tt := range tests {
+		entry, err := ParseOTPAuthURI(tt.uri)
+		if err != nil {
+			t.Errorf("ParseOTPAuthURI(%s): %v", tt.uri, err)
+			continue
+		}
+		if entry.Issuer != tt.issuer || entry.Label != tt.label {
+			t.Errorf("ParseOTPAuthURI(%s) = issuer %q, label %q, want %q, %q", tt.uri, entry.Issuer, entry.Label, tt.issuer, tt.label)
+		}
+	}
+}
+
+// Fields set next to a URI win, and the URI fills in the ones left out
+func TestParseEntryURILabelPrecedence(t *testing.T) {
+	entry, err := ParseEntry(json.RawMessage(`{"secret": "otpauth://totp/GitHub:octocat?secret=JBSWY3DPEHPK3PXP", "label": "work"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Issuer != "GitHub" || entry.Label != "work" {
+		t.Errorf("issuer %q, label %q, want GitHub, work", entry.Issuer, entry.Label)
+	}
+	if got, want := entry.OTPAuthURI(entry.Label), "otpauth://totp/GitHub:work?issuer=GitHub&secret=JBSWY3DPEHPK3PXP"; got != want {
+		t.Errorf("OTPAuthURI = %s, want %s", got, want)
+	}
+}
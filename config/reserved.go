@@ -0,0 +1,68 @@
+package config
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// Keys starting with one of these prefixes are comments, so hand-maintained
+// configs can document their accounts: {"_comment": "work laptop only"}
+var commentPrefixes = []string{"_comment", "//"}
+
+// DefaultKey is the top-level key naming the user whose code is shown when
+// no user ID is given: {"_default": "github"}
+const DefaultKey = "_default"
+
+// AuditKey is the top-level key turning on the audit log of generated codes,
+// true for the default location or the path of the log file:
+// {"_audit": "/var/log/totp-cli/audit.log"}
+const AuditKey = "_audit"
+
+// ClipboardCmdKey is the top-level key giving the command that codes are
+// copied with, like TOTP_CLIPBOARD_CMD: {"_clipboard_cmd": "wl-copy -n"}
+const ClipboardCmdKey = "_clipboard_cmd"
+
+// NoClipboardHostsKey is the top-level key listing the hostnames, which may
+// use * wildcards, where codes aren't copied, like TOTP_NO_CLIPBOARD_HOSTS:
+// {"_no_clipboard_hosts": ["jump*.example.com", "bastion"]}
+const NoClipboardHostsKey = "_no_clipboard_hosts"
+
+// KeychainKey is the top-level key that turns on reading users from the OS
+// keychain, set by keychain-add: {"_keychain": true}
+const KeychainKey = "_keychain"
+
+// IsReserved reports whether a config key is a comment or a setting like
+// DefaultKey rather than a user
+func IsReserved(key string) bool {
+	return key == DefaultKey || key == AuditKey || key == ClipboardCmdKey || key == NoClipboardHostsKey || key == KeychainKey || IsComment(key)
+}
+
+// IsComment reports whether a config key, possibly a "group/name" path, is a
+// comment rather than a user
+func IsComment(key string) bool {
+	for {
+		for _, prefix := range commentPrefixes {
+			if strings.HasPrefix(key, prefix) {
+				return true
+			}
+		}
+		i := strings.Index(key, GroupSeparator)
+		if i < 0 {
+			return false
+		}
+		key = key[i+len(GroupSeparator):]
+	}
+}
+
+// StripReserved removes the comments and settings from a flattened config
+// and returns them, so they can be put back when the config is written
+func StripReserved(raw map[string]json.RawMessage) map[string]json.RawMessage {
+	reserved := make(map[string]json.RawMessage)
+	for key, value := range raw {
+		if IsReserved(key) {
+			reserved[key] = value
+			delete(raw, key)
+		}
+	}
+	return reserved
+}
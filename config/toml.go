@@ -0,0 +1,555 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// The TOML support covers what a config file needs: tables, dotted keys,
+// strings, integers, floats, booleans, arrays and inline tables. Arrays of
+// tables, multi-line strings and dates are rejected.
+
+// decodeTOML parses a TOML document into the same values encoding/json
+// produces: map[string]any, []any, string, json.Number and bool
+func decodeTOML(data []byte) (map[string]any, error) {
+	return newTOMLParser(data).parseDocument()
+}
+
+// tomlHasComments reports whether a TOML document has any # comments
+func tomlHasComments(data []byte) bool {
+	p := newTOMLParser(data)
+	p.parseDocument()
+	return p.comments
+}
+
+func newTOMLParser(data []byte) *tomlParser {
+	return &tomlParser{data: strings.TrimPrefix(string(data), "\uFEFF"), defined: make(map[string]bool)}
+}
+
+// parseDocument parses the whole document into its root table
+func (p *tomlParser) parseDocument() (map[string]any, error) {
+	root := make(map[string]any)
+	current := root
+	for {
+		p.skipBlank()
+		if p.pos >= len(p.data) {
+			return root, nil
+		}
+
+		if p.data[p.pos] == '[' {
+			table, err := p.parseHeader(root)
+			if err != nil {
+				return nil, err
+			}
+			current = table
+			continue
+		}
+
+		keys, err := p.parseKey()
+		if err != nil {
+			return nil, err
+		}
+		p.skipSpace()
+		if !p.consume('=') {
+			return nil, p.errorf("expected '=' after key '%s'", strings.Join(keys, "."))
+		}
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.assign(current, keys, value); err != nil {
+			return nil, err
+		}
+		if err := p.endLine(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// tomlParser walks a TOML document
+type tomlParser struct {
+	data string
+	pos  int
+	// defined records the tables opened by a [header], which can't be repeated
+	defined map[string]bool
+	// comments is set once a comment has been skipped
+	comments bool
+}
+
+func (p *tomlParser) errorf(format string, args ...any) error {
+	line := 1 + strings.Count(p.data[:p.pos], "\n")
+	return fmt.Errorf("toml: line %d: %s", line, fmt.Sprintf(format, args...))
+}
+
+func (p *tomlParser) consume(c byte) bool {
+	if p.pos < len(p.data) && p.data[p.pos] == c {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// skipSpace skips spaces and tabs within a line
+func (p *tomlParser) skipSpace() {
+	for p.pos < len(p.data) && (p.data[p.pos] == ' ' || p.data[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+// skipBlank skips whitespace, newlines and comments
+func (p *tomlParser) skipBlank() {
+	for p.pos < len(p.data) {
+		switch p.data[p.pos] {
+		case ' ', '\t', '\r', '\n':
+			p.pos++
+		case '#':
+			p.skipComment()
+		default:
+			return
+		}
+	}
+}
+
+func (p *tomlParser) skipComment() {
+	p.comments = true
+	for p.pos < len(p.data) && p.data[p.pos] != '\n' {
+		p.pos++
+	}
+}
+
+// endLine expects nothing but a comment before the end of the line
+func (p *tomlParser) endLine() error {
+	p.skipSpace()
+	if p.pos < len(p.data) && p.data[p.pos] == '#' {
+		p.skipComment()
+	}
+	if p.pos < len(p.data) && p.data[p.pos] != '\n' && p.data[p.pos] != '\r' {
+		return p.errorf("unexpected %q at end of line", p.rest())
+	}
+	return nil
+}
+
+// rest returns the remainder of the current line, for error messages
+func (p *tomlParser) rest() string {
+	end := strings.IndexByte(p.data[p.pos:], '\n')
+	if end < 0 {
+		return p.data[p.pos:]
+	}
+	return strings.TrimRight(p.data[p.pos:p.pos+end], "\r")
+}
+
+// parseHeader parses a [table] header, returning the table it opens
+func (p *tomlParser) parseHeader(root map[string]any) (map[string]any, error) {
+	p.pos++ // '['
+	if p.consume('[') {
+		return nil, p.errorf("arrays of tables aren't supported")
+	}
+	p.skipSpace()
+	keys, err := p.parseKey()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if !p.consume(']') {
+		return nil, p.errorf("expected ']' after table name")
+	}
+	if err := p.endLine(); err != nil {
+		return nil, err
+	}
+
+	name := strings.Join(keys, "\x00")
+	if p.defined[name] {
+		return nil, p.errorf("table [%s] is defined twice", strings.Join(keys, "."))
+	}
+	p.defined[name] = true
+	return p.table(root, keys)
+}
+
+// table returns the table at the path of keys below parent, creating any
+// that don't exist yet
+func (p *tomlParser) table(parent map[string]any, keys []string) (map[string]any, error) {
+	for _, key := range keys {
+		value, exists := parent[key]
+		if !exists {
+			child := make(map[string]any)
+			parent[key] = child
+			parent = child
+			continue
+		}
+		child, ok := value.(map[string]any)
+		if !ok {
+			return nil, p.errorf("key '%s' is already set to a value", key)
+		}
+		parent = child
+	}
+	return parent, nil
+}
+
+// assign sets a possibly dotted key in table
+func (p *tomlParser) assign(table map[string]any, keys []string, value any) error {
+	parent, err := p.table(table, keys[:len(keys)-1])
+	if err != nil {
+		return err
+	}
+	key := keys[len(keys)-1]
+	if _, exists := parent[key]; exists {
+		return p.errorf("duplicate key '%s'", strings.Join(keys, "."))
+	}
+	parent[key] = value
+	return nil
+}
+
+// parseKey parses a bare, quoted or dotted key
+func (p *tomlParser) parseKey() ([]string, error) {
+	var keys []string
+	for {
+		p.skipSpace()
+		if p.pos >= len(p.data) {
+			return nil, p.errorf("missing key")
+		}
+		switch c := p.data[p.pos]; {
+		case c == '"' || c == '\'':
+			key, err := p.parseString()
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, key)
+		case isTOMLBareKeyChar(c):
+			start := p.pos
+			for p.pos < len(p.data) && isTOMLBareKeyChar(p.data[p.pos]) {
+				p.pos++
+			}
+			keys = append(keys, p.data[start:p.pos])
+		default:
+			return nil, p.errorf("expected a key, got %q", p.rest())
+		}
+		p.skipSpace()
+		if !p.consume('.') {
+			return keys, nil
+		}
+	}
+}
+
+func isTOMLBareKeyChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
+
+// parseValue parses the value at the current position
+func (p *tomlParser) parseValue() (any, error) {
+	p.skipSpace()
+	if p.pos >= len(p.data) {
+		return nil, p.errorf("missing value")
+	}
+	switch p.data[p.pos] {
+	case '"', '\'':
+		return p.parseString()
+	case '[':
+		return p.parseArray()
+	case '{':
+		return p.parseInlineTable()
+	}
+
+	start := p.pos
+	for p.pos < len(p.data) && strings.IndexByte(" \t\r\n,]}#", p.data[p.pos]) < 0 {
+		p.pos++
+	}
+	token := p.data[start:p.pos]
+	switch token {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+	if n, ok := parseTOMLNumber(token); ok {
+		return n, nil
+	}
+	p.pos = start
+	if tomlDate.MatchString(token) {
+		return nil, p.errorf("dates and times aren't supported, use a string")
+	}
+	return nil, p.errorf("invalid value %q (strings must be quoted)", token)
+}
+
+var (
+	tomlInt   = regexp.MustCompile(`^[-+]?(0|[1-9](_?[0-9])*)$`)
+	tomlRadix = regexp.MustCompile(`^0(x[0-9A-Fa-f](_?[0-9A-Fa-f])*|o[0-7](_?[0-7])*|b[01](_?[01])*)$`)
+	tomlFloat = regexp.MustCompile(`^[-+]?(0|[1-9](_?[0-9])*)(\.[0-9](_?[0-9])*)?([eE][-+]?[0-9](_?[0-9])*)?$`)
+	tomlDate  = regexp.MustCompile(`^[0-9]{4}-[0-9]{2}-[0-9]{2}|^[0-9]{2}:[0-9]{2}`)
+)
+
+// parseTOMLNumber parses an integer or float token
+func parseTOMLNumber(token string) (any, bool) {
+	digits := strings.ReplaceAll(token, "_", "")
+	if tomlInt.MatchString(token) || tomlRadix.MatchString(token) {
+		n, err := strconv.ParseInt(digits, 0, 64)
+		if err != nil {
+			return nil, false
+		}
+		return json.Number(strconv.FormatInt(n, 10)), true
+	}
+	if tomlFloat.MatchString(token) {
+		f, err := strconv.ParseFloat(digits, 64)
+		if err != nil {
+			return nil, false
+		}
+		return json.Number(strconv.FormatFloat(f, 'g', -1, 64)), true
+	}
+	return nil, false
+}
+
+// parseArray parses an array, which may span several lines
+func (p *tomlParser) parseArray() (any, error) {
+	p.pos++ // '['
+	array := []any{}
+	for {
+		p.skipBlank()
+		if p.consume(']') {
+			return array, nil
+		}
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		array = append(array, value)
+		p.skipBlank()
+		if p.consume(']') {
+			return array, nil
+		}
+		if !p.consume(',') {
+			return nil, p.errorf("expected ',' or ']' in array")
+		}
+	}
+}
+
+// parseInlineTable parses a single-line {key = value, ...} table
+func (p *tomlParser) parseInlineTable() (any, error) {
+	p.pos++ // '{'
+	table := make(map[string]any)
+	p.skipSpace()
+	if p.consume('}') {
+		return table, nil
+	}
+	for {
+		keys, err := p.parseKey()
+		if err != nil {
+			return nil, err
+		}
+		p.skipSpace()
+		if !p.consume('=') {
+			return nil, p.errorf("expected '=' after key '%s'", strings.Join(keys, "."))
+		}
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.assign(table, keys, value); err != nil {
+			return nil, err
+		}
+		p.skipSpace()
+		if p.consume('}') {
+			return table, nil
+		}
+		if !p.consume(',') {
+			return nil, p.errorf("expected ',' or '}' in inline table")
+		}
+	}
+}
+
+// parseString parses a basic "..." or literal '...' string
+func (p *tomlParser) parseString() (string, error) {
+	quote := p.data[p.pos]
+	if strings.HasPrefix(p.data[p.pos:], strings.Repeat(string(quote), 3)) {
+		return "", p.errorf("multi-line strings aren't supported")
+	}
+	p.pos++
+
+	var b strings.Builder
+	for p.pos < len(p.data) {
+		c := p.data[p.pos]
+		switch {
+		case c == quote:
+			p.pos++
+			return b.String(), nil
+		case c == '\n':
+			return "", p.errorf("unterminated string")
+		case c == '\\' && quote == '"':
+			if err := p.parseEscape(&b); err != nil {
+				return "", err
+			}
+			continue
+		default:
+			b.WriteByte(c)
+		}
+		p.pos++
+	}
+	return "", p.errorf("unterminated string")
+}
+
+// parseEscape decodes the escape sequence at the current position
+func (p *tomlParser) parseEscape(b *strings.Builder) error {
+	if p.pos+1 >= len(p.data) {
+		return p.errorf("unterminated escape sequence")
+	}
+	e := p.data[p.pos+1]
+	p.pos += 2
+	switch e {
+	case 'b':
+		b.WriteByte('\b')
+	case 't':
+		b.WriteByte('\t')
+	case 'n':
+		b.WriteByte('\n')
+	case 'f':
+		b.WriteByte('\f')
+	case 'r':
+		b.WriteByte('\r')
+	case '"', '\\':
+		b.WriteByte(e)
+	case 'u', 'U':
+		size := 4
+		if e == 'U' {
+			size = 8
+		}
+		if p.pos+size > len(p.data) {
+			return p.errorf("truncated \\%c escape", e)
+		}
+		r, err := strconv.ParseUint(p.data[p.pos:p.pos+size], 16, 32)
+		if err != nil || !utf8.ValidRune(rune(r)) {
+			return p.errorf("invalid \\%c escape", e)
+		}
+		b.WriteRune(rune(r))
+		p.pos += size
+	default:
+		return p.errorf("unsupported escape sequence \\%c", e)
+	}
+	return nil
+}
+
+// encodeTOML writes a config as TOML. Users with settings and groups become
+// [tables], with plain values written before them as TOML requires.
+func encodeTOML(v map[string]any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := writeTOMLTable(&buf, nil, v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeTOMLTable(buf *bytes.Buffer, path []string, table map[string]any) error {
+	keys := make([]string, 0, len(table))
+	values := make(map[string]any, len(table))
+	for key, value := range table {
+		plain, err := plainValue(value)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, key)
+		values[key] = plain
+	}
+	sort.Strings(keys)
+
+	var tables []string
+	headerWritten := path == nil
+	for _, key := range keys {
+		if nested, ok := values[key].(map[string]any); ok && len(nested) > 0 {
+			tables = append(tables, key)
+			continue
+		}
+		if !headerWritten {
+			writeTOMLHeader(buf, path)
+			headerWritten = true
+		}
+		fmt.Fprintf(buf, "%s = ", tomlKey(key))
+		if err := writeTOMLValue(buf, values[key]); err != nil {
+			return err
+		}
+		buf.WriteByte('\n')
+	}
+
+	for _, key := range tables {
+		if err := writeTOMLTable(buf, append(path[:len(path):len(path)], key), values[key].(map[string]any)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeTOMLHeader(buf *bytes.Buffer, path []string) {
+	if buf.Len() > 0 {
+		buf.WriteByte('\n')
+	}
+	quoted := make([]string, len(path))
+	for i, key := range path {
+		quoted[i] = tomlKey(key)
+	}
+	fmt.Fprintf(buf, "[%s]\n", strings.Join(quoted, "."))
+}
+
+// writeTOMLValue writes a value inline
+func writeTOMLValue(buf *bytes.Buffer, value any) error {
+	switch v := value.(type) {
+	case bool:
+		buf.WriteString(strconv.FormatBool(v))
+	case json.Number:
+		buf.WriteString(v.String())
+	case string:
+		quoted, _ := Marshal(v, "")
+		buf.Write(quoted)
+	case []any:
+		buf.WriteByte('[')
+		for i, item := range v {
+			if i > 0 {
+				buf.WriteString(", ")
+			}
+			if err := writeTOMLValue(buf, item); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		buf.WriteByte('{')
+		for i, key := range keys {
+			if i > 0 {
+				buf.WriteString(",")
+			}
+			fmt.Fprintf(buf, " %s = ", tomlKey(key))
+			if err := writeTOMLValue(buf, v[key]); err != nil {
+				return err
+			}
+		}
+		if len(keys) > 0 {
+			buf.WriteByte(' ')
+		}
+		buf.WriteByte('}')
+	case nil:
+		return fmt.Errorf("TOML has no null value")
+	default:
+		return fmt.Errorf("can't write %T as TOML", value)
+	}
+	return nil
+}
+
+// tomlKey writes key bare when it can be, and quoted otherwise
+func tomlKey(key string) string {
+	for i := 0; i < len(key); i++ {
+		if !isTOMLBareKeyChar(key[i]) {
+			quoted, _ := Marshal(key, "")
+			return string(quoted)
+		}
+	}
+	if key == "" {
+		return `""`
+	}
+	return key
+}
@@ -0,0 +1,585 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// The YAML support covers what a config file needs without pulling in a
+// dependency: block mappings and sequences, flow collections on one line,
+// plain and quoted scalars, and comments. Anchors, tags and multi-line
+// block scalars are rejected.
+
+// yamlLine is a non-empty line of a YAML document with its comment removed
+type yamlLine struct {
+	num    int
+	indent int
+	text   string
+}
+
+// decodeYAML parses a YAML document into the same values encoding/json
+// produces: map[string]any, []any, string, json.Number, bool and nil
+func decodeYAML(data []byte) (any, error) {
+	lines, err := splitYAMLLines(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(lines) == 0 {
+		return map[string]any{}, nil
+	}
+	p := &yamlParser{lines: lines}
+	value, err := p.parseBlock(lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.lines) {
+		return nil, p.errorf("unexpected indentation")
+	}
+	return value, nil
+}
+
+// splitYAMLLines breaks a document into lines, dropping blank lines,
+// comments and document markers
+func splitYAMLLines(data []byte) ([]yamlLine, error) {
+	var lines []yamlLine
+	for i, raw := range strings.Split(string(data), "\n") {
+		raw = strings.TrimRight(raw, "\r")
+		if i == 0 {
+			raw = strings.TrimPrefix(raw, "\uFEFF")
+		}
+		text := strings.TrimLeft(raw, " ")
+		if strings.HasPrefix(text, "\t") {
+			return nil, fmt.Errorf("yaml: line %d: tabs can't be used for indentation", i+1)
+		}
+		text = strings.TrimSpace(stripYAMLComment(text))
+		if text == "" {
+			continue
+		}
+		if text == "---" || text == "..." {
+			if len(lines) > 0 {
+				// Only the first document is used
+				break
+			}
+			continue
+		}
+		lines = append(lines, yamlLine{num: i + 1, indent: len(raw) - len(strings.TrimLeft(raw, " ")), text: text})
+	}
+	return lines, nil
+}
+
+// yamlHasComments reports whether a YAML document has any # comments
+func yamlHasComments(data []byte) bool {
+	for _, line := range strings.Split(string(data), "\n") {
+		text := strings.TrimSpace(line)
+		if strings.TrimSpace(stripYAMLComment(text)) != text {
+			return true
+		}
+	}
+	return false
+}
+
+// stripYAMLComment removes a trailing "# comment", ignoring '#' inside
+// quoted scalars or in the middle of a plain one
+func stripYAMLComment(s string) string {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			if i == 0 || strings.IndexByte(" \t:,[{", s[i-1]) >= 0 {
+				quote = c
+			}
+		case c == '#':
+			if i == 0 || s[i-1] == ' ' || s[i-1] == '\t' {
+				return s[:i]
+			}
+		}
+	}
+	return s
+}
+
+// yamlParser walks the lines of a document
+type yamlParser struct {
+	lines []yamlLine
+	pos   int
+}
+
+func (p *yamlParser) errorf(format string, args ...any) error {
+	num := 0
+	if p.pos < len(p.lines) {
+		num = p.lines[p.pos].num
+	} else if len(p.lines) > 0 {
+		num = p.lines[len(p.lines)-1].num
+	}
+	return fmt.Errorf("yaml: line %d: %s", num, fmt.Sprintf(format, args...))
+}
+
+// parseBlock parses the mapping or sequence starting at the current line
+func (p *yamlParser) parseBlock(indent int) (any, error) {
+	if isYAMLSequenceItem(p.lines[p.pos].text) {
+		return p.parseSequence(indent)
+	}
+	return p.parseMapping(indent)
+}
+
+func isYAMLSequenceItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// parseMapping parses "key: value" lines at the given indentation
+func (p *yamlParser) parseMapping(indent int) (any, error) {
+	mapping := make(map[string]any)
+	for p.pos < len(p.lines) && p.lines[p.pos].indent == indent {
+		line := p.lines[p.pos]
+		if isYAMLSequenceItem(line.text) {
+			return nil, p.errorf("expected a \"key: value\" line")
+		}
+		key, rest, err := splitYAMLKey(line.text)
+		if err != nil {
+			return nil, p.errorf("%v", err)
+		}
+		if _, exists := mapping[key]; exists {
+			return nil, p.errorf("duplicate key '%s'", key)
+		}
+		p.pos++
+
+		var value any
+		switch {
+		case rest != "":
+			if value, err = parseYAMLInline(rest); err != nil {
+				p.pos--
+				return nil, p.errorf("%v", err)
+			}
+		case p.pos < len(p.lines) && p.lines[p.pos].indent > indent:
+			if value, err = p.parseBlock(p.lines[p.pos].indent); err != nil {
+				return nil, err
+			}
+		case p.pos < len(p.lines) && p.lines[p.pos].indent == indent && isYAMLSequenceItem(p.lines[p.pos].text):
+			// A sequence may sit at the same indentation as its key
+			if value, err = p.parseSequence(indent); err != nil {
+				return nil, err
+			}
+		}
+		mapping[key] = value
+	}
+	if p.pos < len(p.lines) && p.lines[p.pos].indent > indent {
+		return nil, p.errorf("unexpected indentation")
+	}
+	return mapping, nil
+}
+
+// parseSequence parses "- item" lines at the given indentation
+func (p *yamlParser) parseSequence(indent int) (any, error) {
+	sequence := []any{}
+	for p.pos < len(p.lines) && p.lines[p.pos].indent == indent && isYAMLSequenceItem(p.lines[p.pos].text) {
+		line := p.lines[p.pos]
+		rest := strings.TrimLeft(strings.TrimPrefix(line.text, "-"), " ")
+
+		var value any
+		var err error
+		switch {
+		case rest == "":
+			p.pos++
+			if p.pos < len(p.lines) && p.lines[p.pos].indent > indent {
+				if value, err = p.parseBlock(p.lines[p.pos].indent); err != nil {
+					return nil, err
+				}
+			}
+		case isYAMLSequenceItem(rest) || isYAMLMappingLine(rest):
+			// "- key: value" starts a nested collection whose other lines
+			// line up with its first key
+			p.lines[p.pos] = yamlLine{num: line.num, indent: indent + len(line.text) - len(rest), text: rest}
+			if value, err = p.parseBlock(p.lines[p.pos].indent); err != nil {
+				return nil, err
+			}
+		default:
+			if value, err = parseYAMLInline(rest); err != nil {
+				return nil, p.errorf("%v", err)
+			}
+			p.pos++
+		}
+		sequence = append(sequence, value)
+	}
+	return sequence, nil
+}
+
+// isYAMLMappingLine reports whether text is a "key: value" pair rather than a scalar
+func isYAMLMappingLine(text string) bool {
+	if strings.HasPrefix(text, "{") || strings.HasPrefix(text, "[") {
+		return false
+	}
+	_, _, err := splitYAMLKey(text)
+	return err == nil
+}
+
+// splitYAMLKey splits "key: value" into its unquoted key and the value text
+func splitYAMLKey(text string) (string, string, error) {
+	if text[0] == '"' || text[0] == '\'' {
+		key, n, err := parseYAMLQuoted(text)
+		if err != nil {
+			return "", "", err
+		}
+		rest := text[n:]
+		if !strings.HasPrefix(rest, ":") {
+			return "", "", fmt.Errorf("expected ':' after key %q", key)
+		}
+		return key, strings.TrimSpace(rest[1:]), nil
+	}
+	for i := 0; i < len(text); i++ {
+		if text[i] == ':' && (i+1 == len(text) || text[i+1] == ' ') {
+			return strings.TrimSpace(text[:i]), strings.TrimSpace(text[i+1:]), nil
+		}
+	}
+	return "", "", fmt.Errorf("expected a \"key: value\" line")
+}
+
+// parseYAMLInline parses a value written on a single line: a flow
+// collection or a scalar
+func parseYAMLInline(text string) (any, error) {
+	f := &yamlFlow{text: text}
+	value, err := f.parseValue(false)
+	if err != nil {
+		return nil, err
+	}
+	f.skipSpace()
+	if f.pos < len(f.text) {
+		return nil, fmt.Errorf("unexpected %q after value", f.text[f.pos:])
+	}
+	return value, nil
+}
+
+// yamlFlow parses flow collections like {secret: X, digits: 8} and [a, b]
+type yamlFlow struct {
+	text string
+	pos  int
+}
+
+func (f *yamlFlow) skipSpace() {
+	for f.pos < len(f.text) && f.text[f.pos] == ' ' {
+		f.pos++
+	}
+}
+
+// parseValue parses the value at the current position. Inside a flow
+// collection, plain scalars end at ',', ']' or '}'.
+func (f *yamlFlow) parseValue(inFlow bool) (any, error) {
+	f.skipSpace()
+	if f.pos >= len(f.text) {
+		return nil, fmt.Errorf("missing value")
+	}
+	switch c := f.text[f.pos]; c {
+	case '{':
+		return f.parseFlowMapping()
+	case '[':
+		return f.parseFlowSequence()
+	case '"', '\'':
+		s, n, err := parseYAMLQuoted(f.text[f.pos:])
+		if err != nil {
+			return nil, err
+		}
+		f.pos += n
+		return s, nil
+	case '|', '>':
+		return nil, fmt.Errorf("multi-line block scalars aren't supported, use a quoted string")
+	case '&', '*', '!':
+		return nil, fmt.Errorf("anchors, aliases and tags aren't supported")
+	}
+
+	start := f.pos
+	for f.pos < len(f.text) {
+		c := f.text[f.pos]
+		if inFlow && (c == ',' || c == ']' || c == '}') {
+			break
+		}
+		if inFlow && c == ':' && (f.pos+1 == len(f.text) || strings.IndexByte(" ,]}", f.text[f.pos+1]) >= 0) {
+			break
+		}
+		f.pos++
+	}
+	return parseYAMLPlain(strings.TrimSpace(f.text[start:f.pos])), nil
+}
+
+func (f *yamlFlow) parseFlowMapping() (any, error) {
+	f.pos++ // '{'
+	mapping := make(map[string]any)
+	for {
+		f.skipSpace()
+		if f.pos < len(f.text) && f.text[f.pos] == '}' {
+			f.pos++
+			return mapping, nil
+		}
+		key, err := f.parseValue(true)
+		if err != nil {
+			return nil, err
+		}
+		keyString, ok := key.(string)
+		if !ok {
+			keyString = fmt.Sprint(key)
+		}
+		f.skipSpace()
+		if f.pos >= len(f.text) || f.text[f.pos] != ':' {
+			return nil, fmt.Errorf("expected ':' after key '%s'", keyString)
+		}
+		f.pos++
+		value, err := f.parseValue(true)
+		if err != nil {
+			return nil, err
+		}
+		if _, exists := mapping[keyString]; exists {
+			return nil, fmt.Errorf("duplicate key '%s'", keyString)
+		}
+		mapping[keyString] = value
+		if err := f.parseSeparator('}'); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (f *yamlFlow) parseFlowSequence() (any, error) {
+	f.pos++ // '['
+	sequence := []any{}
+	for {
+		f.skipSpace()
+		if f.pos < len(f.text) && f.text[f.pos] == ']' {
+			f.pos++
+			return sequence, nil
+		}
+		value, err := f.parseValue(true)
+		if err != nil {
+			return nil, err
+		}
+		sequence = append(sequence, value)
+		if err := f.parseSeparator(']'); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// parseSeparator consumes the ',' between flow items, leaving a closing
+// bracket for the caller
+func (f *yamlFlow) parseSeparator(closing byte) error {
+	f.skipSpace()
+	if f.pos >= len(f.text) {
+		return fmt.Errorf("missing '%c'", closing)
+	}
+	switch f.text[f.pos] {
+	case ',':
+		f.pos++
+	case closing:
+	default:
+		return fmt.Errorf("expected ',' or '%c'", closing)
+	}
+	return nil
+}
+
+// parseYAMLQuoted parses a single or double quoted scalar at the start of
+// s, returning its value and length
+func parseYAMLQuoted(s string) (string, int, error) {
+	quote := s[0]
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		if quote == '\'' {
+			if c == '\'' {
+				if i+1 < len(s) && s[i+1] == '\'' {
+					b.WriteByte('\'')
+					i++
+					continue
+				}
+				return b.String(), i + 1, nil
+			}
+			b.WriteByte(c)
+			continue
+		}
+
+		switch c {
+		case '"':
+			return b.String(), i + 1, nil
+		case '\\':
+			if i+1 >= len(s) {
+				return "", 0, fmt.Errorf("unterminated escape sequence")
+			}
+			i++
+			switch e := s[i]; e {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case 'r':
+				b.WriteByte('\r')
+			case '0':
+				b.WriteByte(0)
+			case '"', '\\', '/', ' ':
+				b.WriteByte(e)
+			case 'x', 'u', 'U':
+				size := map[byte]int{'x': 2, 'u': 4, 'U': 8}[e]
+				if i+size >= len(s) {
+					return "", 0, fmt.Errorf("truncated \\%c escape", e)
+				}
+				r, err := strconv.ParseUint(s[i+1:i+1+size], 16, 32)
+				if err != nil || !utf8.ValidRune(rune(r)) {
+					return "", 0, fmt.Errorf("invalid \\%c escape", e)
+				}
+				b.WriteRune(rune(r))
+				i += size
+			default:
+				return "", 0, fmt.Errorf("unsupported escape sequence \\%c", e)
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", 0, fmt.Errorf("unterminated quoted string")
+}
+
+var (
+	yamlInt   = regexp.MustCompile(`^[-+]?[0-9]+$`)
+	yamlFloat = regexp.MustCompile(`^[-+]?([0-9]*\.[0-9]+|[0-9]+\.[0-9]*)([eE][-+]?[0-9]+)?$`)
+)
+
+// parseYAMLPlain resolves an unquoted scalar to a number, boolean, null or string
+func parseYAMLPlain(s string) any {
+	switch s {
+	case "", "~", "null", "Null", "NULL":
+		return nil
+	case "true", "True", "TRUE":
+		return true
+	case "false", "False", "FALSE":
+		return false
+	}
+	if yamlInt.MatchString(s) {
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return json.Number(strconv.FormatInt(n, 10))
+		}
+	}
+	if yamlFloat.MatchString(s) {
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return json.Number(strconv.FormatFloat(f, 'g', -1, 64))
+		}
+	}
+	return s
+}
+
+// encodeYAML writes a config as block-style YAML. Mappings are written as
+// nested blocks; sequences are written in flow style, which keeps the
+// encoder small and reads back the same.
+func encodeYAML(v map[string]any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := writeYAMLMapping(&buf, v, 0); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeYAMLMapping(buf *bytes.Buffer, mapping map[string]any, indent int) error {
+	keys := make([]string, 0, len(mapping))
+	for key := range mapping {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value, err := plainValue(mapping[key])
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(buf, "%s%s:", strings.Repeat(" ", indent), yamlString(key))
+		if nested, ok := value.(map[string]any); ok && len(nested) > 0 {
+			buf.WriteByte('\n')
+			if err := writeYAMLMapping(buf, nested, indent+2); err != nil {
+				return err
+			}
+			continue
+		}
+		buf.WriteByte(' ')
+		if err := writeYAMLFlow(buf, value); err != nil {
+			return err
+		}
+		buf.WriteByte('\n')
+	}
+	return nil
+}
+
+// writeYAMLFlow writes a value on a single line
+func writeYAMLFlow(buf *bytes.Buffer, value any) error {
+	switch v := value.(type) {
+	case nil:
+		buf.WriteString("null")
+	case bool:
+		buf.WriteString(strconv.FormatBool(v))
+	case json.Number:
+		buf.WriteString(v.String())
+	case string:
+		buf.WriteString(yamlString(v))
+	case []any:
+		buf.WriteByte('[')
+		for i, item := range v {
+			if i > 0 {
+				buf.WriteString(", ")
+			}
+			if err := writeYAMLFlow(buf, item); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		buf.WriteByte('{')
+		for i, key := range keys {
+			if i > 0 {
+				buf.WriteString(", ")
+			}
+			fmt.Fprintf(buf, "%s: ", yamlString(key))
+			if err := writeYAMLFlow(buf, v[key]); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	default:
+		return fmt.Errorf("can't write %T as YAML", value)
+	}
+	return nil
+}
+
+// plainValue turns the raw JSON entries of a nested config into the plain
+// values the YAML writer handles
+func plainValue(v any) (any, error) {
+	raw, ok := v.(json.RawMessage)
+	if !ok {
+		return v, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var value any
+	if err := dec.Decode(&value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// yamlString writes s as a plain scalar when that reads back as the same
+// string, and double quoted otherwise
+func yamlString(s string) string {
+	if s != "" && s == strings.TrimSpace(s) && parseYAMLPlain(s) == any(s) &&
+		!strings.ContainsAny(s, ":#,[]{}\"'\\\n\t") && strings.IndexAny(s[:1], "-?!&*|>%@`") < 0 {
+		return s
+	}
+	quoted, _ := Marshal(s, "")
+	return string(quoted)
+}
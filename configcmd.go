@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"github.com/nsvirk/totp-cli/config"
+)
+
+// configCmdEnvVar names the environment variable holding a command whose
+// output is read as a JSON config, e.g. to fetch secrets from a secret
+// manager instead of keeping them on disk
+const configCmdEnvVar = "TOTP_CONFIG_CMD"
+
+// commandSource reads entries from the JSON a command prints. The command
+// runs in the shell, so pipes work, and inherits the terminal for any
+// prompts the secret manager shows.
+type commandSource struct {
+	command string
+}
+
+func (s commandSource) entries() (map[string]json.RawMessage, error) {
+	if strings.TrimSpace(s.command) == "" {
+		return nil, config.ErrNotFound
+	}
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", s.command)
+	} else {
+		cmd = exec.Command("sh", "-c", s.command)
+	}
+	var stdout bytes.Buffer
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, &stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%s command '%s' failed: %v", configCmdEnvVar, s.command, err)
+	}
+
+	raw, err := config.ParseRaw(stdout.Bytes(), config.FormatJSON)
+	if err != nil {
+		return nil, fmt.Errorf("%s command '%s': %v", configCmdEnvVar, s.command, err)
+	}
+	for user, value := range raw {
+		// The counter couldn't be saved anywhere
+		if entry, err := config.ParseEntry(value); err == nil && entry.IsHOTP() {
+			warn(fmt.Sprintf("Ignoring HOTP user '%s' from %s: HOTP entries need a config file to keep their counter", user, configCmdEnvVar))
+			delete(raw, user)
+		}
+	}
+	return raw, nil
+}
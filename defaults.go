@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/nsvirk/totp-cli/config"
+	"github.com/nsvirk/totp-cli/totp"
+)
+
+// Environment variables setting the defaults for entries that don't give
+// their own algorithm, digits or period
+const (
+	algorithmEnvVar = "TOTP_ALGORITHM"
+	digitsEnvVar    = "TOTP_DIGITS"
+	periodEnvVar    = "TOTP_PERIOD"
+)
+
+// envDefaults holds the settings from TOTP_ALGORITHM, TOTP_DIGITS and
+// TOTP_PERIOD, loaded at startup
+var envDefaults config.Entry
+
+// loadEnvDefaults reads and validates the default settings from the
+// environment. Unset variables leave the built-in defaults in place.
+func loadEnvDefaults() (config.Entry, error) {
+	var defaults config.Entry
+	if value := strings.TrimSpace(os.Getenv(algorithmEnvVar)); value != "" {
+		if _, err := totp.HashFunc(value); err != nil {
+			return config.Entry{}, fmt.Errorf("invalid %s: %v", algorithmEnvVar, err)
+		}
+		defaults.Algorithm = strings.ToUpper(value)
+	}
+	if value := strings.TrimSpace(os.Getenv(digitsEnvVar)); value != "" {
+		digits, err := strconv.Atoi(value)
+		if err != nil || digits < totp.MinDigits || digits > totp.MaxDigits {
+			return config.Entry{}, fmt.Errorf("invalid %s '%s' (must be between %d and %d)", digitsEnvVar, value, totp.MinDigits, totp.MaxDigits)
+		}
+		defaults.Digits = digits
+	}
+	if value := strings.TrimSpace(os.Getenv(periodEnvVar)); value != "" {
+		period, err := strconv.Atoi(value)
+		if err != nil || period <= 0 {
+			return config.Entry{}, fmt.Errorf("invalid %s '%s' (must be a positive number of seconds)", periodEnvVar, value)
+		}
+		defaults.Period = period
+	}
+	return defaults, nil
+}
+
+// applyDefaults fills in the settings the entry doesn't set itself from
+// envDefaults, so per-user settings take precedence over the environment.
+// otpauth:// URIs are left alone, since a setting missing from a URI means
+// the format's default rather than "not set".
+func applyDefaults(entry config.Entry) config.Entry {
+	if entry.FromURI {
+		return entry
+	}
+	if entry.Algorithm == "" {
+		entry.Algorithm = envDefaults.Algorithm
+	}
+	if entry.Digits == 0 && !entry.IsSteam() {
+		entry.Digits = envDefaults.Digits
+	}
+	if entry.Period == 0 && !entry.IsHOTP() {
+		entry.Period = envDefaults.Period
+	}
+	return entry
+}
+
+// parseEntry decodes a config value like config.ParseEntry, with the
+// defaults from the environment applied
+func parseEntry(value json.RawMessage) (config.Entry, error) {
+	entry, err := config.ParseEntry(value)
+	if err != nil {
+		return config.Entry{}, err
+	}
+	return applyDefaults(entry), nil
+}
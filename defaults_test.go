@@ -0,0 +1,36 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/nsvirk/totp-cli/config"
+)
+
+// Environment defaults fill in plain secrets, but a URI's settings are its own
+func TestApplyDefaultsSkipsURIs(t *testing.T) {
+	saved := envDefaults
+	t.Cleanup(func() { envDefaults = saved })
+	envDefaults = config.Entry{Algorithm: "SHA256", Digits: 8, Period: 60}
+
+	for _, tt := range []struct {
+		value  string
+		digits int
+		period int
+	}{
+		{`"JBSWY3DPEHPK3PXP"`, 8, 60},
+		{`{"secret": "JBSWY3DPEHPK3PXP", "digits": 7}`, 7, 60},
+		{`"otpauth://totp/GitHub:octocat?secret=JBSWY3DPEHPK3PXP"`, 6, 30},
+		{`"otpauth://totp/GitHub:octocat?secret=JBSWY3DPEHPK3PXP&period=45"`, 6, 45},
+		{`{"secret": "otpauth://totp/GitHub:octocat?secret=JBSWY3DPEHPK3PXP", "digits": 7}`, 7, 30},
+	} {
+		entry, err := parseEntry(json.RawMessage(tt.value))
+		if err != nil {
+			t.Fatalf("parseEntry(%s): %v", tt.value, err)
+		}
+		opts := entry.Options()
+		if opts.Digits != tt.digits || opts.Period != tt.period {
+			t.Errorf("parseEntry(%s) = %d digits, %ds, want %d digits, %ds", tt.value, opts.Digits, opts.Period, tt.digits, tt.period)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nsvirk/totp-cli/config"
+)
+
+func TestLoadConfigEmpty(t *testing.T) {
+	t.Setenv(configCmdEnvVar, "")
+	for _, content := range []string{
+		"{}",
+		"",
+		"  \n\t\n",
+		`{"_comment": "nothing here yet"}`,
+		`{"work": {}}`,
+	} {
+		path := filepath.Join(t.TempDir(), "config.json")
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		_, err := loadConfig(path, true)
+		if !errors.Is(err, config.ErrEmpty) {
+			t.Errorf("loadConfig(%q) error = %v, want ErrEmpty", content, err)
+			continue
+		}
+		if !strings.Contains(err.Error(), "totp add") {
+			t.Errorf("loadConfig(%q) error = %q, want a hint on adding entries", content, err)
+		}
+		if code := configExitCode(err); code != exitConfigMissing {
+			t.Errorf("configExitCode(%q) = %d, want %d", content, code, exitConfigMissing)
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"runtime"
+	"strings"
+
+	"github.com/nsvirk/totp-cli/config"
+)
+
+// passphraseEnvVar names the environment variable holding the config passphrase
+const passphraseEnvVar = "TOTP_PASSPHRASE"
+
+// cachedPassphrases remembers the passphrase of each config file, by
+// canonical path, so it's only asked for once per run
+var cachedPassphrases = map[string]string{}
+
+// decryptConfig decrypts a config file with the passphrase from
+// TOTP_PASSPHRASE, or else the first that works of an earlier prompt for this
+// file, a running agent, another file's passphrase from this run, or a new
+// terminal prompt
+func decryptConfig(configPath string, data []byte) ([]byte, string, error) {
+	if passphrase := os.Getenv(passphraseEnvVar); passphrase != "" {
+		plaintext, err := config.Decrypt(data, passphrase)
+		return plaintext, passphrase, err
+	}
+
+	key := canonicalPath(configPath)
+	tried := map[string]bool{}
+	try := func(passphrase string) ([]byte, bool) {
+		if tried[passphrase] {
+			return nil, false
+		}
+		tried[passphrase] = true
+		plaintext, err := config.Decrypt(data, passphrase)
+		if err != nil {
+			return nil, false
+		}
+		cachedPassphrases[key] = passphrase
+		return plaintext, true
+	}
+
+	if passphrase, ok := cachedPassphrases[key]; ok {
+		if plaintext, ok := try(passphrase); ok {
+			return plaintext, passphrase, nil
+		}
+	}
+	if passphrase, ok := agentPassphrase(configPath); ok {
+		if plaintext, ok := try(passphrase); ok {
+			return plaintext, passphrase, nil
+		}
+	}
+	for path, passphrase := range cachedPassphrases {
+		if path == key {
+			continue
+		}
+		if plaintext, ok := try(passphrase); ok {
+			return plaintext, passphrase, nil
+		}
+	}
+
+	// Say which file it's for once another one has been unlocked
+	prompt := "🔒 Config passphrase: "
+	if len(cachedPassphrases) > 0 {
+		prompt = fmt.Sprintf("🔒 Passphrase for %s: ", configPath)
+	}
+	passphrase, err := promptPassphrase(prompt)
+	if err != nil {
+		return nil, "", err
+	}
+	plaintext, err := config.Decrypt(data, passphrase)
+	if err != nil {
+		return nil, "", err
+	}
+	cachedPassphrases[key] = passphrase
+	return plaintext, passphrase, nil
+}
+
+// newPassphrase asks for a passphrase to encrypt with, prompting twice so a
+// typo doesn't lock the user out. TOTP_PASSPHRASE is used as-is when set.
+func newPassphrase() (string, error) {
+	if passphrase := os.Getenv(passphraseEnvVar); passphrase != "" {
+		return passphrase, nil
+	}
+
+	passphrase, err := promptPassphrase("🔒 New passphrase: ")
+	if err != nil {
+		return "", err
+	}
+	if passphrase == "" {
+		return "", fmt.Errorf("passphrase can't be empty")
+	}
+	confirm, err := promptPassphrase("🔒 Confirm passphrase: ")
+	if err != nil {
+		return "", err
+	}
+	if passphrase != confirm {
+		return "", fmt.Errorf("passphrases don't match")
+	}
+	return passphrase, nil
+}
+
+// promptPassphrase reads a line from the terminal without echoing it
+func promptPassphrase(prompt string) (string, error) {
+	if !isTerminal(os.Stdin) {
+		return "", fmt.Errorf("config file is encrypted; set %s or run from a terminal", passphraseEnvVar)
+	}
+
+	fmt.Fprint(os.Stderr, prompt)
+	setEcho(false)
+
+	// Restore echo if the user gives up with Ctrl-C
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-interrupt:
+			setEcho(true)
+			fmt.Fprintln(os.Stderr)
+			os.Exit(130)
+		case <-done:
+		}
+	}()
+
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	close(done)
+	signal.Stop(interrupt)
+	setEcho(true)
+	fmt.Fprintln(os.Stderr)
+
+	if err != nil && line == "" {
+		return "", fmt.Errorf("could not read passphrase: %v", err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// isTerminal reports whether a file is attached to a terminal
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// setEcho turns terminal echo on or off. Windows consoles are left alone.
+func setEcho(on bool) {
+	if runtime.GOOS == "windows" {
+		return
+	}
+	mode := "-echo"
+	if on {
+		mode = "echo"
+	}
+	cmd := exec.Command("stty", mode)
+	cmd.Stdin = os.Stdin
+	cmd.Run()
+}
+
+// readConfigData reads the config file, decrypting it first if needed. The
+// returned passphrase is empty for plaintext files and is needed to write an
+// encrypted file back.
+func readConfigData(configPath string) ([]byte, string, error) {
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, "", fmt.Errorf("error reading config file: %v", err)
+	}
+	if !config.IsEncrypted(data) {
+		return data, "", nil
+	}
+
+	return decryptConfig(configPath, data)
+}
+
+// writeConfigData atomically replaces the config file, encrypting the
+// contents when a passphrase is given. The file's permissions are kept.
+func writeConfigData(configPath string, data []byte, passphrase string) error {
+	perm := os.FileMode(0600)
+	if info, err := os.Stat(configPath); err == nil {
+		perm = info.Mode().Perm()
+	}
+
+	if passphrase != "" {
+		var err error
+		if data, err = config.Encrypt(data, passphrase); err != nil {
+			return fmt.Errorf("could not encrypt config: %v", err)
+		}
+	}
+	return writeFileAtomic(configPath, data, perm)
+}
+
+// encryptConfigFile encrypts a plaintext config file in place
+func encryptConfigFile(configPath string) error {
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return fmt.Errorf("error reading config file: %v", err)
+	}
+	if config.IsEncrypted(data) {
+		return fmt.Errorf("config file is already encrypted: %s", configPath)
+	}
+	if _, err := config.Decode(data, config.FormatOf(configPath)); err != nil {
+		return fmt.Errorf("%v, fix it before encrypting", err)
+	}
+
+	passphrase, err := newPassphrase()
+	if err != nil {
+		return err
+	}
+	return writeConfigData(configPath, data, passphrase)
+}
+
+// decryptConfigFile turns an encrypted config file back into plaintext
+func decryptConfigFile(configPath string) error {
+	data, passphrase, err := readConfigData(configPath)
+	if err != nil {
+		return err
+	}
+	if passphrase == "" {
+		return fmt.Errorf("config file is not encrypted: %s", configPath)
+	}
+	return writeConfigData(configPath, data, "")
+}
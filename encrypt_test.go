@@ -0,0 +1,71 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nsvirk/totp-cli/config"
+)
+
+func TestReadConfigDataPassphrases(t *testing.T) {
+	// No agent, no passphrase in the environment, and no terminal to prompt on
+	t.Setenv(passphraseEnvVar, "")
+	t.Setenv(agentSocketEnvVar, filepath.Join(t.TempDir(), "agent.sock"))
+	t.Cleanup(func() { clear(cachedPassphrases) })
+	dir := t.TempDir()
+	stdin, err := os.Create(filepath.Join(dir, "stdin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdin.Close()
+	oldStdin := os.Stdin
+	os.Stdin = stdin
+	t.Cleanup(func() { os.Stdin = oldStdin })
+
+	writeEncrypted := func(name, passphrase string) string {
+		data, err := config.Encrypt([]byte(`{"`+name+`": "JBSWY3DPEHPK3PXP"}`), passphrase)
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(dir, name+".json")
+		if err := os.WriteFile(path, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	work := writeEncrypted("work", "work passphrase")
+	home := writeEncrypted("home", "home passphrase")
+	shared := writeEncrypted("shared", "work passphrase")
+
+	read := func(path string) (string, error) {
+		data, _, err := readConfigData(path)
+		return string(data), err
+	}
+
+	// Files with different passphrases each use their own
+	cachedPassphrases[canonicalPath(work)] = "work passphrase"
+	cachedPassphrases[canonicalPath(home)] = "home passphrase"
+	for _, path := range []string{work, home, work} {
+		if data, err := read(path); err != nil || !strings.Contains(data, filepath.Base(strings.TrimSuffix(path, ".json"))) {
+			t.Errorf("readConfigData(%s) = %q, %v", filepath.Base(path), data, err)
+		}
+	}
+
+	// A file sharing another's passphrase is opened without a prompt, and
+	// remembered under its own path
+	if _, err := read(shared); err != nil {
+		t.Errorf("readConfigData(shared) with a known passphrase: %v", err)
+	}
+	if got := cachedPassphrases[canonicalPath(shared)]; got != "work passphrase" {
+		t.Errorf("cached passphrase for shared = %q, want the work one", got)
+	}
+
+	// A stale cached passphrase falls through to a prompt instead of failing
+	clear(cachedPassphrases)
+	cachedPassphrases[canonicalPath(home)] = "old passphrase"
+	if _, err := read(home); err == nil || !strings.Contains(err.Error(), "run from a terminal") {
+		t.Errorf("readConfigData(home) with a stale passphrase = %v, want a prompt", err)
+	}
+}
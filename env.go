@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/nsvirk/totp-cli/config"
+)
+
+// secretEnvPrefix starts the names of environment variables holding secrets,
+// e.g. TOTP_SECRET_GITHUB for the user "github"
+const secretEnvPrefix = "TOTP_SECRET_"
+
+// envGroupSeparator stands in for the group separator in variable names,
+// which can't contain '/': TOTP_SECRET_WORK__GITHUB is "work/github"
+const envGroupSeparator = "__"
+
+// envSource reads entries from TOTP_SECRET_<USER> environment variables, for
+// containers and CI jobs that can't ship a config file
+type envSource struct {
+	environ []string
+}
+
+func (s envSource) entries() (map[string]json.RawMessage, error) {
+	raw := make(map[string]json.RawMessage)
+	names := make(map[string]string)
+	for _, variable := range s.environ {
+		name, value, _ := strings.Cut(variable, "=")
+		if !strings.HasPrefix(name, secretEnvPrefix) || strings.TrimSpace(value) == "" {
+			continue
+		}
+		user := envUser(name)
+		if user == "" {
+			continue
+		}
+		if other, exists := names[user]; exists {
+			warn(fmt.Sprintf("Ignoring %s: %s already sets user '%s'", name, other, user))
+			continue
+		}
+
+		entry, err := envValue(value)
+		if err != nil {
+			warn(fmt.Sprintf("Ignoring %s: %v", name, err))
+			continue
+		}
+		raw[user] = entry
+		names[user] = name
+	}
+	if len(raw) == 0 {
+		return nil, config.ErrNotFound
+	}
+	return raw, nil
+}
+
+// envUser derives the user ID from a variable name: the part after the
+// prefix, lowercased, with "__" separating groups
+func envUser(name string) string {
+	parts := strings.Split(strings.ToLower(strings.TrimPrefix(name, secretEnvPrefix)), envGroupSeparator)
+	for _, part := range parts {
+		if part == "" {
+			return ""
+		}
+	}
+	return strings.Join(parts, config.GroupSeparator)
+}
+
+// envValue turns a variable's value into a raw config entry. It's a secret
+// or otpauth:// URI, or a JSON object with per-user settings. HOTP entries
+// aren't allowed since their counter can't be saved.
+func envValue(value string) (json.RawMessage, error) {
+	value = strings.TrimSpace(value)
+	var raw json.RawMessage
+	if strings.HasPrefix(value, "{") {
+		if !json.Valid([]byte(value)) {
+			return nil, fmt.Errorf("invalid JSON object")
+		}
+		raw = json.RawMessage(value)
+	} else {
+		var err error
+		if raw, err = config.Marshal(value, ""); err != nil {
+			return nil, err
+		}
+	}
+
+	entry, err := config.ParseEntry(raw)
+	if err != nil {
+		return nil, err
+	}
+	if entry.IsHOTP() {
+		return nil, fmt.Errorf("HOTP entries need a config file to keep their counter")
+	}
+	return raw, nil
+}
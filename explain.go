@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/nsvirk/totp-cli/config"
+	"github.com/nsvirk/totp-cli/totp"
+)
+
+// explainOutput is the JSON form of --explain. The secret itself is never
+// included, only the length of the key it decodes to.
+type explainOutput struct {
+	User      string  `json:"user"`
+	Type      string  `json:"type,omitempty"`
+	Algorithm string  `json:"algorithm,omitempty"`
+	Digits    int     `json:"digits,omitempty"`
+	Period    int     `json:"period,omitempty"`
+	Counter   *uint64 `json:"counter,omitempty"`
+	KeyBytes  int     `json:"key_bytes,omitempty"`
+	Secrets   int     `json:"secrets,omitempty"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// describeEntry resolves the settings of a user's entry
+func describeEntry(user string, entry config.Entry) explainOutput {
+	opts := entry.Options()
+	out := explainOutput{
+		User:      user,
+		Type:      "totp",
+		Algorithm: opts.Algorithm,
+		Digits:    opts.Digits,
+		Period:    opts.Period,
+	}
+	switch {
+	case entry.IsHOTP():
+		out.Type, out.Period, out.Counter = "hotp", 0, entry.Counter
+	case entry.IsSteam():
+		out.Type, out.Digits = config.SteamType, totp.SteamCodeLength
+	}
+	if key, err := entry.Key(); err != nil {
+		out.Error = err.Error()
+	} else {
+		out.KeyBytes = len(key)
+	}
+	if entry.IsRotating() {
+		out.Secrets = len(entry.Secrets)
+	}
+	return out
+}
+
+// explainEntries prints the settings each entry resolves to, for debugging
+// codes that don't match the server's, without generating any codes or
+// revealing the secrets. It exits with exitInvalidSecret if a secret
+// doesn't decode.
+func explainEntries(userIDs []string, entries []config.Entry) {
+	failed := false
+	for i, entry := range entries {
+		out := describeEntry(userIDs[i], entry)
+		if out.Error != "" {
+			failed = true
+		}
+
+		if jsonOutput {
+			printJSON(os.Stdout, out)
+			continue
+		}
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Println(formatField("👤", "User", colorize(ansiCyan, entry.DisplayName(userIDs[i]))))
+		fmt.Println(formatField("🏷️", "Type", out.Type))
+		fmt.Println(formatField("🧮", "Algorithm", out.Algorithm))
+		fmt.Println(formatField("🔢", "Digits", strconv.Itoa(out.Digits)))
+		if out.Counter != nil {
+			fmt.Println(formatField("🔁", "Counter", strconv.FormatUint(*out.Counter, 10)))
+		} else {
+			fmt.Println(formatField("⏱️", "Period", fmt.Sprintf("%ds", out.Period)))
+		}
+		if out.Error != "" {
+			fmt.Println(formatField("🔑", "Key", colorize(ansiYellow, out.Error)))
+		} else {
+			fmt.Println(formatField("🔑", "Key", fmt.Sprintf("%d bytes (%d bits)", out.KeyBytes, out.KeyBytes*8)))
+		}
+		if out.Secrets > 0 {
+			fmt.Println(formatField("🔄", "Secrets", fmt.Sprintf("%d, rotating (the key is the newest one's)", out.Secrets)))
+		}
+	}
+	if failed {
+		os.Exit(exitInvalidSecret)
+	}
+}
+
+// runInfo prints the resolved settings of every user as a table, for
+// auditing a config at a glance (e.g. for accounts still on SHA1). No codes
+// are generated and no secrets are shown.
+func runInfo(args []string) {
+	var configFlag string
+	positional, err := parseCommandArgs(args, nil, map[string]*string{"--config": &configFlag})
+	if err != nil {
+		fatal(fmt.Sprintf("Error: %v", err))
+	}
+	if len(positional) != 0 {
+		fatal("Usage: info [--config <path>]")
+	}
+	configPath := commandConfigPath(configFlag)
+	raw, err := loadRawEntries(configPath)
+	if err != nil {
+		failWith(configExitCode(err), []string{"config", configPath}, fmt.Sprintf("Error: %v", err))
+	}
+
+	users := make([]string, 0, len(raw))
+	for user := range raw {
+		users = append(users, user)
+	}
+	sort.Strings(users)
+
+	rows := make([]explainOutput, 0, len(users))
+	for _, user := range users {
+		entry, err := parseEntry(raw[user])
+		if err != nil {
+			rows = append(rows, explainOutput{User: user, Error: err.Error()})
+			continue
+		}
+		rows = append(rows, describeEntry(user, entry))
+	}
+
+	if jsonOutput {
+		printJSON(os.Stdout, rows)
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "USER\tTYPE\tALGORITHM\tDIGITS\tPERIOD\tKEY")
+	for _, row := range rows {
+		if row.Type == "" {
+			fmt.Fprintf(w, "%s\t-\t-\t-\t-\t%s\n", row.User, row.Error)
+			continue
+		}
+		period := fmt.Sprintf("%ds", row.Period)
+		if row.Counter != nil {
+			period = fmt.Sprintf("counter %d", *row.Counter)
+		}
+		key := fmt.Sprintf("%d bits", row.KeyBytes*8)
+		if row.Error != "" {
+			key = row.Error
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\n", row.User, row.Type, row.Algorithm, row.Digits, period, key)
+	}
+	w.Flush()
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"sort"
+
+	"github.com/nsvirk/totp-cli/config"
+	"github.com/nsvirk/totp-cli/totp"
+)
+
+// runExport prints every account in the config for moving to another
+// device: as a Google Authenticator otpauth-migration:// URI by default, or
+// as one otpauth:// URI per account with --uris. --qr renders them as QR
+// codes to scan instead.
+func runExport(args []string) {
+	var configFlag string
+	var uris, qr bool
+	positional, err := parseCommandArgs(args,
+		map[string]*bool{"--uris": &uris, "--qr": &qr},
+		map[string]*string{"--config": &configFlag})
+	if err != nil {
+		fatal(fmt.Sprintf("Error: %v", err))
+	}
+	if len(positional) != 0 {
+		fatal("Usage: export [--uris] [--qr] [--config <path>]")
+	}
+	configPath := commandConfigPath(configFlag)
+	raw, err := loadRawEntries(configPath)
+	if err != nil {
+		failWith(configExitCode(err), []string{"config", configPath}, fmt.Sprintf("Error: %v", err))
+	}
+
+	users := make([]string, 0, len(raw))
+	for user := range raw {
+		users = append(users, user)
+	}
+	sort.Strings(users)
+
+	var labels, texts []string
+	var accounts []googleAccount
+	for _, user := range users {
+		entry, err := parseEntry(raw[user])
+		if err != nil {
+			warn(fmt.Sprintf("Skipping '%s': %v", user, err))
+			continue
+		}
+		label := user
+		if entry.Label != "" {
+			label = entry.Label
+		}
+		if uris {
+			labels = append(labels, user)
+			texts = append(texts, entry.OTPAuthURI(label))
+			continue
+		}
+		account, err := exportGoogleAccount(label, entry)
+		if err != nil {
+			warn(fmt.Sprintf("Skipping '%s': %v (export it with --uris instead)", user, err))
+			continue
+		}
+		accounts = append(accounts, account)
+	}
+
+	if len(texts) == 0 && len(accounts) == 0 {
+		fatal("Error: no accounts to export")
+	}
+
+	if !uris {
+		batches := [][]googleAccount{accounts}
+		if qr {
+			batches = qrBatches(accounts)
+		}
+		batchID, err := randomBatchID()
+		if err != nil {
+			fatal(fmt.Sprintf("Error: %v", err))
+		}
+		for i, batch := range batches {
+			labels = append(labels, fmt.Sprintf("batch %d of %d, %d account(s)", i+1, len(batches), len(batch)))
+			texts = append(texts, googleMigrationURI(batch, len(batches), i, batchID))
+		}
+	}
+
+	warn("The export contains your secrets, don't share or store it anywhere unencrypted")
+	for i, text := range texts {
+		if !qr {
+			fmt.Println(text)
+			continue
+		}
+		code, err := encodeQR(text)
+		if err != nil {
+			fatal(fmt.Sprintf("Error: %s: %v", labels[i], err))
+		}
+		fmt.Print(renderQR(code))
+		fmt.Printf("👤 %s\n\n", labels[i])
+	}
+}
+
+// exportGoogleAccount converts a config entry into an account in Google
+// Authenticator's migration format, which only knows 30 second TOTP and
+// HOTP with 6 or 8 digits
+func exportGoogleAccount(label string, entry config.Entry) (googleAccount, error) {
+	secret, err := entry.Key()
+	if err != nil {
+		return googleAccount{}, err
+	}
+	opts := entry.Options()
+	account := googleAccount{Secret: secret, Name: label, Issuer: entry.Issuer, Type: googleTypeTOTP}
+
+	switch {
+	case entry.IsSteam():
+		return googleAccount{}, fmt.Errorf("Steam Guard accounts can't be exported in the migration format")
+	case entry.IsHOTP():
+		account.Type = googleTypeHOTP
+		account.Counter = *entry.Counter
+	case opts.Period != totp.DefaultPeriod:
+		return googleAccount{}, fmt.Errorf("a %ds period can't be exported in the migration format", opts.Period)
+	}
+
+	switch opts.Algorithm {
+	case "SHA1":
+		account.Algorithm = googleAlgorithmSHA1
+	case "SHA256":
+		account.Algorithm = googleAlgorithmSHA256
+	case "SHA512":
+		account.Algorithm = googleAlgorithmSHA512
+	}
+	switch opts.Digits {
+	case 6:
+		account.Digits = googleDigitsSix
+	case 8:
+		account.Digits = googleDigitsEight
+	default:
+		return googleAccount{}, fmt.Errorf("%d digit codes can't be exported in the migration format", opts.Digits)
+	}
+	return account, nil
+}
+
+// qrBatches splits accounts into batches whose migration URIs each fit in a
+// QR code. Batch numbering is encoded in single bytes either way, so sizes
+// measured before the batches are numbered still hold.
+func qrBatches(accounts []googleAccount) [][]googleAccount {
+	var batches [][]googleAccount
+	var batch []googleAccount
+	for _, account := range accounts {
+		if len(batch) > 0 {
+			if _, err := encodeQR(googleMigrationURI(append(batch, account), 1, 0, ^uint32(0))); err != nil {
+				batches = append(batches, batch)
+				batch = nil
+			}
+		}
+		batch = append(batch, account)
+	}
+	if len(batch) > 0 {
+		batches = append(batches, batch)
+	}
+	return batches
+}
+
+// randomBatchID returns the ID tying together the batches of one export
+func randomBatchID() (uint32, error) {
+	var b [4]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint32(b[:]), nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// formatPresets are the named templates --format accepts in place of a
+// template string
+var formatPresets = map[string]string{
+	"code":  "{{.Code}}",
+	"short": "{{.User}}: {{.Code}}",
+	"full":  "{{.User}}{{if .Rotation}} ({{.Rotation}}){{end}}: {{.Code}}{{if .HOTP}} (counter {{.Counter}}){{else}} (expires in {{.ExpiresIn}}s){{end}}",
+	"tsv":   "{{.User}}\t{{.Code}}\t{{.ExpiresIn}}",
+}
+
+// formatData is what a --format template is executed with, once per user
+type formatData struct {
+	// User is the user ID and Name its display name (the user ID unless
+	// the entry sets a label)
+	User string
+	Name string
+	// Code is the bare code, without --group spacing
+	Code string
+	// Previous and Next are only set with --adjacent
+	Previous string
+	Next     string
+	// ExpiresIn, Elapsed, Period and Expires are zero for HOTP users
+	ExpiresIn int
+	Elapsed   int
+	Period    int
+	Expires   time.Time
+	HOTP      bool
+	Counter   uint64
+	// Rotation is "old" or "new" for users with a list of secrets
+	Rotation string
+}
+
+// formatPresetNames returns the preset names in sorted order, for messages
+func formatPresetNames() string {
+	names := make([]string, 0, len(formatPresets))
+	for name := range formatPresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// parseFormat compiles the --format value, either a preset name or a
+// template string. The template is also run once against sample data, so
+// mistakes like unknown fields are reported before any code is generated.
+func parseFormat(value string) (*template.Template, error) {
+	text := value
+	if preset, ok := formatPresets[value]; ok {
+		text = preset
+	}
+	tmpl, err := template.New("format").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	sample := formatData{User: "user", Name: "user", Code: "123456", ExpiresIn: 30, Period: 30, Expires: time.Now()}
+	if err := tmpl.Execute(io.Discard, sample); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+// printFormatted writes one line per user with the --format template,
+// leaving out the last newline with --no-newline
+func printFormatted(tmpl *template.Template, data []formatData, noNewline bool) error {
+	lines := make([]string, len(data))
+	for i, d := range data {
+		var b strings.Builder
+		if err := tmpl.Execute(&b, d); err != nil {
+			return err
+		}
+		lines[i] = strings.TrimSuffix(b.String(), "\n")
+	}
+	fmt.Fprint(os.Stdout, strings.Join(lines, "\n"))
+	if !noNewline {
+		fmt.Fprintln(os.Stdout)
+	}
+	return nil
+}
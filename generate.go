@@ -0,0 +1,186 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/nsvirk/totp-cli/config"
+	"github.com/nsvirk/totp-cli/totp"
+)
+
+// Random secret lengths in bytes for generate-secret. The default is the
+// 160 bits RFC 4226 recommends; anything over maxSecretLength is pointless.
+const (
+	defaultSecretLength = 20
+	maxSecretLength     = 64
+)
+
+// runGenerateSecret creates a random secret for a user, stores it, and
+// prints the otpauth:// URI and QR code to enroll it on the server or app.
+// An existing user's secret is only replaced with --force, or added as the
+// new secret of a rotation with --rotate.
+func runGenerateSecret(args []string) {
+	var configFlag, lengthFlag, issuer string
+	var force, rotate, uriOnly bool
+	positional, err := parseCommandArgs(args,
+		map[string]*bool{"--force": &force, "--rotate": &rotate, "--uri-only": &uriOnly},
+		map[string]*string{"--config": &configFlag, "--length": &lengthFlag, "--issuer": &issuer})
+	if err != nil {
+		fatal(fmt.Sprintf("Error: %v", err))
+	}
+	if len(positional) != 1 {
+		fatal("Usage: generate-secret <user_id> [--length <bytes>] [--issuer <name>] [--force | --rotate] [--uri-only] [--config <path>]")
+	}
+	if force && rotate {
+		fatal("--force and --rotate can't be combined")
+	}
+	length := defaultSecretLength
+	if lengthFlag != "" {
+		if length, err = strconv.Atoi(lengthFlag); err != nil || length < totp.MinKeyLength || length > maxSecretLength {
+			fatal(fmt.Sprintf("Invalid --length value '%s': must be between %d and %d bytes", lengthFlag, totp.MinKeyLength, maxSecretLength))
+		}
+	}
+	user := positional[0]
+	if config.IsReserved(user) {
+		fatal(fmt.Sprintf("Error: '%s' is reserved for comments and settings, pick another user ID", user))
+	}
+	configPath := commandConfigPath(configFlag)
+
+	key := make([]byte, length)
+	if _, err := rand.Read(key); err != nil {
+		fatal(fmt.Sprintf("Error: could not generate a secret: %v", err))
+	}
+
+	var saved json.RawMessage
+	err = updateRawConfig(configPath, true, func(raw map[string]json.RawMessage) error {
+		existing, exists := findKey(raw, user)
+		if exists && !force && !rotate {
+			return fmt.Errorf("user '%s' already exists (use --force to replace its secret, or --rotate to keep the old one valid alongside the new one)", existing)
+		}
+		if !exists {
+			if rotate {
+				return fmt.Errorf("user '%s' doesn't exist, so there's no secret to rotate", user)
+			}
+			value, err := newSecretValue(key, issuer)
+			if err != nil {
+				return err
+			}
+			raw[user], saved = value, value
+			return nil
+		}
+
+		value, err := replaceSecret(raw[existing], key, rotate, issuer)
+		if err != nil {
+			return fmt.Errorf("invalid entry for user '%s': %v", existing, err)
+		}
+		raw[existing], saved, user = value, value, existing
+		return nil
+	})
+	if err != nil {
+		fatal(fmt.Sprintf("Error: %v", err))
+	}
+	entry, err := parseEntry(saved)
+	if err != nil {
+		fatal(fmt.Sprintf("Error: %v", err))
+	}
+
+	switch {
+	case rotate:
+		fmt.Printf("🔄 Added a new %d-bit secret for '%s' to %s, the old one stays valid\n", length*8, user, configPath)
+	case force:
+		fmt.Printf("✅ Replaced the secret of '%s' in %s with a new %d-bit one\n", user, configPath, length*8)
+	default:
+		fmt.Printf("✅ Added '%s' to %s with a new %d-bit secret\n", user, configPath, length*8)
+	}
+	printFingerprint(entry)
+
+	label := user
+	if entry.Label != "" {
+		label = entry.Label
+	}
+	uri := entry.OTPAuthURI(label)
+	fmt.Println(uri)
+	if uriOnly {
+		return
+	}
+	qr, err := encodeQR(uri)
+	if err != nil {
+		fatal(fmt.Sprintf("Error: %v", err))
+	}
+	fmt.Print(renderQR(qr))
+	fmt.Printf("👤 Scan to enroll '%s', the URI above holds the same secret\n", user)
+}
+
+// newSecretValue returns the config value of a new user: the bare base32
+// secret, or an object when there's an issuer to keep with it
+func newSecretValue(key []byte, issuer string) (json.RawMessage, error) {
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(key)
+	if issuer == "" {
+		return config.Marshal(secret, "")
+	}
+	return config.Marshal(map[string]string{"secret": secret, "issuer": issuer}, "")
+}
+
+// replaceSecret puts key in place of an existing entry's secret, or after
+// its secrets with rotate, written in the entry's encoding. Other settings
+// are kept, otpauth:// URIs are expanded first so the key can replace
+// theirs, and HOTP counters start over.
+func replaceSecret(value json.RawMessage, key []byte, rotate bool, issuer string) (json.RawMessage, error) {
+	entry, err := parseEntry(value)
+	if err != nil {
+		return nil, err
+	}
+	if rotate && entry.IsHOTP() {
+		return nil, fmt.Errorf("HOTP entries can't have a list of secrets, use --force to replace the secret")
+	}
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(key)
+	switch strings.ToLower(entry.Encoding) {
+	case totp.EncodingHex:
+		secret = hex.EncodeToString(key)
+	case totp.EncodingBase32Hex:
+		secret = base32.HexEncoding.WithPadding(base32.NoPadding).EncodeToString(key)
+	}
+	var newSecret any = secret
+	if rotate {
+		secrets := entry.Secrets
+		if secrets == nil {
+			secrets = []string{entry.Secret}
+		}
+		newSecret = append(secrets, secret)
+	}
+
+	// Plain strings and lists stay that way, unless an issuer is added or
+	// there's a URI to expand
+	var unresolved config.Entry
+	if err := json.Unmarshal(value, &unresolved); err != nil {
+		return nil, err
+	}
+	isURI := strings.HasPrefix(unresolved.Secret, "otpauth://")
+	fields := make(map[string]json.RawMessage)
+	if isURI {
+		if value, err = migrateEntry(value); err != nil {
+			return nil, err
+		}
+	}
+	if err := json.Unmarshal(value, &fields); err != nil && !isURI && issuer == "" {
+		return config.Marshal(newSecret, "")
+	}
+
+	if fields["secret"], err = config.Marshal(newSecret, ""); err != nil {
+		return nil, err
+	}
+	if issuer != "" {
+		if fields["issuer"], err = config.Marshal(issuer, ""); err != nil {
+			return nil, err
+		}
+	}
+	if entry.IsHOTP() {
+		fields["counter"] = json.RawMessage("0")
+	}
+	return config.Marshal(fields, "")
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/nsvirk/totp-cli/config"
+)
+
+// isUserPattern reports whether a user argument is a glob pattern like
+// 'work/*' rather than a user ID or prefix
+func isUserPattern(userArg string) bool {
+	return strings.ContainsAny(userArg, "*?[")
+}
+
+// matchUserPattern returns the user IDs matching a glob pattern, compared
+// case-insensitively, in sorted order. As with paths, * doesn't match the /
+// between a group and its users, so 'work/*' leaves out work/team/github.
+func matchUserPattern(cfg config.Config, pattern string) ([]string, error) {
+	pattern = strings.ToLower(pattern)
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+	var matches []string
+	for _, user := range sortedUsers(cfg) {
+		if ok, _ := path.Match(pattern, strings.ToLower(user)); ok {
+			matches = append(matches, user)
+		}
+	}
+	return matches, nil
+}
+
+// lookupPattern finds the users matching a pattern given on the command line,
+// exiting with the available users when there are none
+func lookupPattern(cfg config.Config, pattern string) []string {
+	matches, err := matchUserPattern(cfg, pattern)
+	if err != nil {
+		fatal(fmt.Sprintf("Invalid pattern '%s': %v", pattern, err))
+	}
+	if len(matches) == 0 {
+		var hints []string
+		if users := sortedUsers(cfg); len(users) > 0 {
+			hints = append(hints, fmt.Sprintf("Available users: %s", strings.Join(users, ", ")))
+		}
+		failWith(exitUserNotFound, []string{"pattern", pattern}, fmt.Sprintf("No users match '%s'", pattern), hints...)
+	}
+	return matches
+}
+
+// skipPatternHOTP leaves out the HOTP users a pattern matched, so asking for
+// a group's codes doesn't use up counter values. HOTP users named on their
+// own are kept.
+func skipPatternHOTP(userIDs []string, entries []config.Entry, matched []bool) ([]string, []config.Entry) {
+	var keptIDs []string
+	var kept []config.Entry
+	for i, entry := range entries {
+		if matched[i] && entry.IsHOTP() {
+			warn(fmt.Sprintf("skipped HOTP user '%s' so its counter doesn't advance, name it to generate a code", userIDs[i]))
+			continue
+		}
+		keptIDs = append(keptIDs, userIDs[i])
+		kept = append(kept, entry)
+	}
+	return keptIDs, kept
+}
+
+// printCodeTable prints the codes of several users in a table like --all,
+// with the secrets of a rotation on their own rows
+func printCodeTable(userIDs []string, entries []config.Entry, codes []string) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "USER\tCODE\tEXPIRES")
+	for i, code := range codes {
+		user := userIDs[i]
+		if entries[i].Rotation != "" {
+			user += " (" + entries[i].Rotation + ")"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%ds\n", user, displayCode(code), secondsRemaining(entries[i].Options().Period))
+	}
+	w.Flush()
+}
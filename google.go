@@ -0,0 +1,310 @@
+package main
+
+import (
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/nsvirk/totp-cli/config"
+)
+
+// googleAccount is a single account from a Google Authenticator export
+type googleAccount struct {
+	Secret    []byte
+	Name      string
+	Issuer    string
+	Algorithm int64
+	Digits    int64
+	Type      int64
+	Counter   uint64
+}
+
+// Field values used by the Google Authenticator migration protobuf
+const (
+	googleAlgorithmSHA1   = 1
+	googleAlgorithmSHA256 = 2
+	googleAlgorithmSHA512 = 3
+	googleDigitsSix       = 1
+	googleDigitsEight     = 2
+	googleTypeHOTP        = 1
+	googleTypeTOTP        = 2
+)
+
+// parseGoogleMigration decodes an otpauth-migration://offline?data=... URI
+// as exported by Google Authenticator
+func parseGoogleMigration(uri string) ([]googleAccount, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "otpauth-migration" {
+		return nil, fmt.Errorf("expected an otpauth-migration:// URI, got scheme '%s'", u.Scheme)
+	}
+	data := u.Query().Get("data")
+	if data == "" {
+		return nil, fmt.Errorf("missing data parameter")
+	}
+
+	// The payload is standard base64, but may have lost its padding or had
+	// '+' turned into spaces on the way
+	data = strings.TrimRight(strings.ReplaceAll(data, " ", "+"), "=")
+	payload, err := base64.RawStdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base64 payload: %v", err)
+	}
+
+	var accounts []googleAccount
+	err = decodeProtobuf(payload, func(field int, wireType int, value []byte, number uint64) error {
+		// Field 1 is a repeated OtpParameters message; the batch fields are ignored
+		if field != 1 || wireType != 2 {
+			return nil
+		}
+		account, err := parseGoogleAccount(value)
+		if err != nil {
+			return err
+		}
+		accounts = append(accounts, account)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid migration payload: %v", err)
+	}
+	return accounts, nil
+}
+
+// parseGoogleAccount decodes a single OtpParameters message
+func parseGoogleAccount(data []byte) (googleAccount, error) {
+	var account googleAccount
+	err := decodeProtobuf(data, func(field int, wireType int, value []byte, number uint64) error {
+		switch field {
+		case 1:
+			account.Secret = value
+		case 2:
+			account.Name = string(value)
+		case 3:
+			account.Issuer = string(value)
+		case 4:
+			account.Algorithm = int64(number)
+		case 5:
+			account.Digits = int64(number)
+		case 6:
+			account.Type = int64(number)
+		case 7:
+			account.Counter = number
+		}
+		return nil
+	})
+	return account, err
+}
+
+// decodeProtobuf walks the fields of a protobuf message, calling fn with the
+// bytes of length-delimited fields or the number of varint fields
+func decodeProtobuf(data []byte, fn func(field int, wireType int, value []byte, number uint64) error) error {
+	for len(data) > 0 {
+		tag, n := binary.Uvarint(data)
+		if n <= 0 {
+			return fmt.Errorf("malformed field tag")
+		}
+		data = data[n:]
+		field, wireType := int(tag>>3), int(tag&7)
+
+		var value []byte
+		var number uint64
+		switch wireType {
+		case 0: // varint
+			number, n = binary.Uvarint(data)
+			if n <= 0 {
+				return fmt.Errorf("malformed varint in field %d", field)
+			}
+			data = data[n:]
+		case 1: // 64-bit
+			if len(data) < 8 {
+				return fmt.Errorf("truncated field %d", field)
+			}
+			data = data[8:]
+		case 2: // length-delimited
+			length, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < length {
+				return fmt.Errorf("truncated field %d", field)
+			}
+			value = data[n : n+int(length)]
+			data = data[n+int(length):]
+		case 5: // 32-bit
+			if len(data) < 4 {
+				return fmt.Errorf("truncated field %d", field)
+			}
+			data = data[4:]
+		default:
+			return fmt.Errorf("unsupported wire type %d in field %d", wireType, field)
+		}
+
+		if err := fn(field, wireType, value, number); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// appendProtobufVarint appends a varint field to a protobuf message
+func appendProtobufVarint(data []byte, field int, number uint64) []byte {
+	data = binary.AppendUvarint(data, uint64(field)<<3)
+	return binary.AppendUvarint(data, number)
+}
+
+// appendProtobufBytes appends a length-delimited field to a protobuf message
+func appendProtobufBytes(data []byte, field int, value []byte) []byte {
+	data = binary.AppendUvarint(data, uint64(field)<<3|2)
+	data = binary.AppendUvarint(data, uint64(len(value)))
+	return append(data, value...)
+}
+
+// marshal encodes the account as an OtpParameters message, the inverse of
+// parseGoogleAccount
+func (a googleAccount) marshal() []byte {
+	var data []byte
+	data = appendProtobufBytes(data, 1, a.Secret)
+	data = appendProtobufBytes(data, 2, []byte(a.Name))
+	if a.Issuer != "" {
+		data = appendProtobufBytes(data, 3, []byte(a.Issuer))
+	}
+	data = appendProtobufVarint(data, 4, uint64(a.Algorithm))
+	data = appendProtobufVarint(data, 5, uint64(a.Digits))
+	data = appendProtobufVarint(data, 6, uint64(a.Type))
+	if a.Type == googleTypeHOTP {
+		data = appendProtobufVarint(data, 7, a.Counter)
+	}
+	return data
+}
+
+// googleMigrationURI encodes accounts as an otpauth-migration://offline URI,
+// one batch of batchSize sharing the batchID
+func googleMigrationURI(accounts []googleAccount, batchSize, batchIndex int, batchID uint32) string {
+	var payload []byte
+	for _, account := range accounts {
+		payload = appendProtobufBytes(payload, 1, account.marshal())
+	}
+	payload = appendProtobufVarint(payload, 2, 1) // version
+	payload = appendProtobufVarint(payload, 3, uint64(batchSize))
+	payload = appendProtobufVarint(payload, 4, uint64(batchIndex))
+	payload = appendProtobufVarint(payload, 5, uint64(batchID))
+
+	query := url.Values{}
+	query.Set("data", base64.StdEncoding.EncodeToString(payload))
+	return "otpauth-migration://offline?" + query.Encode()
+}
+
+// configValue converts the account into a config value: an object with its
+// settings and display names, or a plain base32 secret when it has neither
+func (a googleAccount) configValue() (json.RawMessage, error) {
+	entry := config.Entry{
+		Secret: base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(a.Secret),
+		Issuer: strings.TrimSpace(a.Issuer),
+		Label:  strings.TrimSpace(strings.TrimPrefix(a.Name, a.Issuer+":")),
+	}
+	if entry.Label == a.userKey() {
+		// Nothing nicer to show than the user ID itself
+		entry.Label = ""
+	}
+	switch a.Algorithm {
+	case 0, googleAlgorithmSHA1:
+	case googleAlgorithmSHA256:
+		entry.Algorithm = "SHA256"
+	case googleAlgorithmSHA512:
+		entry.Algorithm = "SHA512"
+	default:
+		return nil, fmt.Errorf("unsupported algorithm")
+	}
+	if a.Digits == googleDigitsEight {
+		entry.Digits = 8
+	}
+	if a.Type == googleTypeHOTP {
+		counter := a.Counter
+		entry.Counter = &counter
+	}
+
+	if entry.Algorithm == "" && entry.Digits == 0 && entry.Counter == nil && entry.Issuer == "" && entry.Label == "" {
+		return config.Marshal(entry.Secret, "")
+	}
+	return config.Marshal(entry, "")
+}
+
+// invalidKeyChars matches characters that are awkward in a user ID typed on
+// the command line
+var invalidKeyChars = regexp.MustCompile(`[^a-z0-9@._-]+`)
+
+// userKey derives a config key from the account's issuer and name, e.g.
+// "GitHub" and "GitHub:octocat" become "github_octocat"
+func (a googleAccount) userKey() string {
+	name := a.Name
+	if a.Issuer != "" {
+		name = strings.TrimPrefix(name, a.Issuer+":")
+		name = a.Issuer + "_" + name
+	}
+	key := invalidKeyChars.ReplaceAllString(strings.ToLower(strings.TrimSpace(name)), "_")
+	key = strings.Trim(key, "_")
+	if key == "" {
+		key = "account"
+	}
+	return key
+}
+
+// runImportGoogle adds every account from a Google Authenticator export to the config
+func runImportGoogle(args []string) {
+	var configFlag string
+	var force bool
+	positional, err := parseCommandArgs(args,
+		map[string]*bool{"--force": &force},
+		map[string]*string{"--config": &configFlag})
+	if err != nil {
+		fatal(fmt.Sprintf("Error: %v", err))
+	}
+	if len(positional) != 1 {
+		fatal("Usage: import-google <otpauth-migration://...> [--force] [--config <path>]")
+	}
+	configPath := commandConfigPath(configFlag)
+
+	accounts, err := parseGoogleMigration(positional[0])
+	if err != nil {
+		fatal(fmt.Sprintf("Error: %v", err))
+	}
+	if len(accounts) == 0 {
+		fatal("Error: the export doesn't contain any accounts")
+	}
+
+	var added, skipped []string
+	err = updateRawConfig(configPath, true, func(raw map[string]json.RawMessage) error {
+		for _, account := range accounts {
+			key := account.userKey()
+			value, err := account.configValue()
+			if err != nil {
+				warn(fmt.Sprintf("Skipping '%s': %v", key, err))
+				skipped = append(skipped, key)
+				continue
+			}
+			if existing, ok := findKey(raw, key); ok {
+				if !force {
+					warn(fmt.Sprintf("Skipping '%s': user already exists (use --force to replace it)", existing))
+					skipped = append(skipped, key)
+					continue
+				}
+				delete(raw, existing)
+			}
+			raw[key] = value
+			added = append(added, key)
+		}
+		return nil
+	})
+	if err != nil {
+		fatal(fmt.Sprintf("Error: %v", err))
+	}
+
+	for _, key := range added {
+		fmt.Printf("✅ Imported '%s'\n", key)
+	}
+	fmt.Printf("\n%d imported, %d skipped into %s\n", len(added), len(skipped), configPath)
+}
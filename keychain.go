@@ -0,0 +1,268 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/nsvirk/totp-cli/config"
+)
+
+// keychainService is the service name secrets are stored under in the OS keychain
+const keychainService = "totp-cli"
+
+// keychainIndex is the keychain account holding the newline-separated list
+// of stored user IDs, since the keychains can't portably list their items
+const keychainIndex = "_index"
+
+// keychainEnvVar turns the keychain on or off for a run, over the config's
+// _keychain setting
+const keychainEnvVar = "TOTP_KEYCHAIN"
+
+// errKeychainUnavailable reports that this system has no usable keychain
+var errKeychainUnavailable = errors.New("keychain not available")
+
+// keychain stores values in the operating system's credential store: the
+// macOS Keychain, the Secret Service on Linux or the Windows Credential Manager
+type keychain interface {
+	// get returns the value stored for account, and false if there is none
+	get(account string) (string, bool, error)
+	// set stores value for account, replacing any existing one
+	set(account, value string) error
+}
+
+// configSource is a backend that config entries can be read from
+type configSource interface {
+	// entries returns the raw entries keyed by user ID, or an error wrapping
+	// config.ErrNotFound if the source holds no config at all
+	entries() (map[string]json.RawMessage, error)
+}
+
+// fileSource reads entries from the config file
+type fileSource struct {
+	path string
+}
+
+func (s fileSource) entries() (map[string]json.RawMessage, error) {
+	if _, err := os.Stat(s.path); os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: %s", config.ErrNotFound, s.path)
+	}
+	if err := checkConfigPermissions(s.path); err != nil {
+		return nil, err
+	}
+	return readRawConfig(s.path)
+}
+
+// keychainSource reads entries from the OS keychain
+type keychainSource struct {
+	keychain keychain
+}
+
+func (s keychainSource) entries() (map[string]json.RawMessage, error) {
+	users, err := keychainUsers(s.keychain)
+	if err != nil {
+		return nil, err
+	}
+	if len(users) == 0 {
+		return nil, config.ErrNotFound
+	}
+
+	raw := make(map[string]json.RawMessage, len(users))
+	for _, user := range users {
+		secret, ok, err := s.keychain.get(user)
+		if err != nil {
+			return nil, fmt.Errorf("reading '%s' from the keychain: %v", user, err)
+		}
+		if !ok {
+			// Removed from the keychain behind our back; the index is stale
+			continue
+		}
+		value, err := config.Marshal(secret, "")
+		if err != nil {
+			return nil, err
+		}
+		raw[user] = value
+	}
+	return raw, nil
+}
+
+// extraConfigPaths are the config files given with a repeated --config,
+// merged over the first one in order
+var extraConfigPaths []string
+
+// keychainEnabled reports whether users are read from the OS keychain: when
+// TOTP_KEYCHAIN is set, by its value, and otherwise by the _keychain setting
+// of the config files, which keychain-add turns on. Asking the keychain
+// runs a helper program, which can be slow or ask to unlock it, so it's only
+// done for those who use it.
+func keychainEnabled(configPath string) bool {
+	if value := strings.TrimSpace(os.Getenv(keychainEnvVar)); value != "" {
+		return value != "0" && !strings.EqualFold(value, "false")
+	}
+	enabled := false
+	for _, setting := range readSettings(configPath)[config.KeychainKey] {
+		if err := json.Unmarshal(setting.value, &enabled); err != nil {
+			warn(fmt.Sprintf("Ignoring %s in %s: it must be true or false", config.KeychainKey, setting.path))
+		}
+	}
+	return enabled
+}
+
+// loadRawEntries merges the entries from the config file, any extra config
+// files, the TOTP_CONFIG_CMD command, the OS keychain if it's enabled and
+// TOTP_SECRET_<USER> environment variables, with later sources taking
+// precedence. A missing or unusable keychain silently leaves the others.
+func loadRawEntries(configPath string) (map[string]json.RawMessage, error) {
+	sources := []configSource{fileSource{configPath}}
+	for _, path := range extraConfigPaths {
+		sources = append(sources, fileSource{path})
+	}
+	sources = append(sources, commandSource{os.Getenv(configCmdEnvVar)})
+	if keychainEnabled(configPath) {
+		sources = append(sources, keychainSource{newKeychain()})
+	}
+	sources = append(sources, envSource{os.Environ()})
+	origins := make(map[string]string)
+
+	var raw map[string]json.RawMessage
+	for _, source := range sources {
+		entries, err := source.entries()
+		if errors.Is(err, config.ErrNotFound) || errors.Is(err, errKeychainUnavailable) {
+			continue
+		}
+		if err != nil {
+			if _, ok := source.(keychainSource); ok {
+				warn(fmt.Sprintf("Ignoring the keychain: %v", err))
+				continue
+			}
+			return nil, err
+		}
+		if raw == nil {
+			raw = make(map[string]json.RawMessage, len(entries))
+		}
+		// Let a later source replace an entry regardless of case, without
+		// touching keys of the same source that differ only by case
+		for user := range entries {
+			for key := range raw {
+				if strings.EqualFold(key, user) {
+					// Warn when one config file overrides another, unlike
+					// the keychain and environment which override on purpose
+					if file, ok := source.(fileSource); ok && origins[key] != "" {
+						warn(fmt.Sprintf("User '%s' in %s overrides '%s' in %s", user, file.path, key, origins[key]))
+					}
+					delete(raw, key)
+				}
+			}
+		}
+		for user, value := range entries {
+			raw[user] = value
+			origins[user] = ""
+			if file, ok := source.(fileSource); ok {
+				origins[user] = file.path
+			}
+		}
+	}
+	if raw == nil {
+		return nil, fmt.Errorf("%w: %s", config.ErrNotFound, configPath)
+	}
+	if len(raw) == 0 {
+		return nil, fmt.Errorf("%w in %s", config.ErrEmpty, configPath)
+	}
+	return raw, nil
+}
+
+// keychainUsers returns the user IDs recorded in the keychain index
+func keychainUsers(kc keychain) ([]string, error) {
+	index, ok, err := kc.get(keychainIndex)
+	if err != nil || !ok {
+		return nil, err
+	}
+	var users []string
+	for _, user := range strings.Split(index, "\n") {
+		if user = strings.TrimSpace(user); user != "" {
+			users = append(users, user)
+		}
+	}
+	return users, nil
+}
+
+// runKeychainAdd stores a user's secret in the OS keychain instead of the
+// config file
+func runKeychainAdd(args []string) {
+	var force bool
+	var configFlag string
+	positional, err := parseCommandArgs(args, map[string]*bool{"--force": &force}, map[string]*string{"--config": &configFlag})
+	if err != nil {
+		fatal(fmt.Sprintf("Error: %v", err))
+	}
+	if len(positional) != 2 {
+		fatal("Usage: keychain-add <user_id> <secret> [--force] [--config <path>]")
+	}
+	user, secret := positional[0], positional[1]
+	if user == keychainIndex {
+		fatal(fmt.Sprintf("Error: '%s' is reserved and can't be used as a user ID", keychainIndex))
+	}
+
+	value, err := config.Marshal(secret, "")
+	if err != nil {
+		fatal(fmt.Sprintf("Error: %v", err))
+	}
+	entry, err := config.ParseEntry(value)
+	if err == nil {
+		err = entry.Check()
+	}
+	if err != nil {
+		failWith(exitInvalidSecret, []string{"user", user}, fmt.Sprintf("Error: invalid secret for user '%s': %v", user, err))
+	}
+	if entry.IsHOTP() {
+		// The counter has to be written back after every code
+		fatal("Error: HOTP entries must be stored in the config file", "Use 'add' instead")
+	}
+
+	kc := newKeychain()
+	users, err := keychainUsers(kc)
+	if err != nil {
+		fatal(fmt.Sprintf("Error: %v", err), "Use 'add' to store the secret in the config file instead")
+	}
+	exists := false
+	for _, existing := range users {
+		if strings.EqualFold(existing, user) {
+			if !force {
+				fatal(fmt.Sprintf("Error: user '%s' already exists in the keychain (use --force to replace it)", existing))
+			}
+			user = existing
+			exists = true
+		}
+	}
+
+	if err := kc.set(user, secret); err != nil {
+		fatal(fmt.Sprintf("Error: storing '%s' in the keychain: %v", user, err))
+	}
+	if !exists {
+		users = append(users, user)
+		sort.Strings(users)
+		if err := kc.set(keychainIndex, strings.Join(users, "\n")); err != nil {
+			fatal(fmt.Sprintf("Error: updating the keychain index: %v", err))
+		}
+	}
+	fmt.Printf("🔐 Added '%s' to the keychain\n", user)
+	printFingerprint(entry)
+
+	// The keychain is only read once the config asks for it
+	configPath := commandConfigPath(configFlag)
+	if keychainEnabled(configPath) {
+		return
+	}
+	err = updateConfigFile(configPath, true, func(_, reserved map[string]json.RawMessage) error {
+		reserved[config.KeychainKey] = json.RawMessage("true")
+		return nil
+	})
+	if err != nil {
+		warn(fmt.Sprintf("Could not turn on the keychain in %s: %v; set %s=1 to use it", configPath, err, keychainEnvVar))
+		return
+	}
+	fmt.Printf("⚙️ Turned on the keychain with %s in %s\n", config.KeychainKey, configPath)
+}
@@ -0,0 +1,62 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// fakeKeychainTools puts security and secret-tool stand-ins first on PATH
+// that record being run in the returned file and find nothing
+func fakeKeychainTools(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	marker := filepath.Join(dir, "ran")
+	script := "#!/bin/sh\necho \"$0 $*\" >> '" + marker + "'\nexit 1\n"
+	for _, name := range []string{"security", "secret-tool"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(script), 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return marker
+}
+
+func TestKeychainOnlyWhenEnabled(t *testing.T) {
+	t.Setenv(configCmdEnvVar, "")
+	t.Setenv(keychainEnvVar, "")
+	for _, tt := range []struct {
+		name    string
+		content string
+		env     string
+		want    bool
+	}{
+		{"unset", `{"github": "JBSWY3DPEHPK3PXP"}`, "", false},
+		{"setting", `{"_keychain": true, "github": "JBSWY3DPEHPK3PXP"}`, "", true},
+		{"setting off", `{"_keychain": false, "github": "JBSWY3DPEHPK3PXP"}`, "", false},
+		{"env", `{"github": "JBSWY3DPEHPK3PXP"}`, "1", true},
+		{"env over setting", `{"_keychain": true, "github": "JBSWY3DPEHPK3PXP"}`, "0", false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			marker := fakeKeychainTools(t)
+			t.Setenv(keychainEnvVar, tt.env)
+			path := filepath.Join(t.TempDir(), "config.json")
+			if err := os.WriteFile(path, []byte(tt.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+			raw, err := loadRawEntries(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := raw["github"]; !ok {
+				t.Errorf("loadRawEntries = %v, want the config's github user", raw)
+			}
+			_, err = os.Stat(marker)
+			if ran := err == nil; ran != tt.want {
+				t.Errorf("keychain tool ran = %v, want %v", ran, tt.want)
+			}
+		})
+	}
+}
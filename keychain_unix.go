@@ -0,0 +1,82 @@
+//go:build !windows
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// keychainTimeout bounds each call to the keychain tool, which runs on every
+// invocation. It's longer than clipboardTimeout to leave time for a prompt
+// to unlock the keychain, but a hung D-Bus or agent can't freeze the CLI.
+const keychainTimeout = 10 * time.Second
+
+// commandKeychain talks to the keychain through the platform's command line
+// tool: security on macOS and secret-tool (libsecret) elsewhere
+type commandKeychain struct{}
+
+func newKeychain() keychain {
+	return commandKeychain{}
+}
+
+func (commandKeychain) get(account string) (string, bool, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "darwin" {
+		cmd = exec.Command("security", "find-generic-password", "-s", keychainService, "-a", account, "-w")
+	} else {
+		cmd = exec.Command("secret-tool", "lookup", "service", keychainService, "account", account)
+	}
+	if _, err := exec.LookPath(cmd.Args[0]); err != nil {
+		return "", false, errKeychainUnavailable
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := runWithTimeout(cmd, keychainTimeout); err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			return "", false, err
+		}
+		// security exits 44 for a missing item; secret-tool exits 1 without
+		// printing anything
+		if exitErr.ExitCode() == 44 || (exitErr.ExitCode() == 1 && stderr.Len() == 0) {
+			return "", false, nil
+		}
+		return "", false, fmt.Errorf("%s: %s", cmd.Args[0], strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSuffix(stdout.String(), "\n"), true, nil
+}
+
+func (commandKeychain) set(account, value string) error {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "darwin" {
+		// -U updates the item if it already exists. A -w without a value,
+		// last, makes security prompt for the password and its confirmation
+		// on stdin, keeping it out of the process list.
+		cmd = exec.Command("security", "add-generic-password", "-U", "-s", keychainService, "-a", account, "-w")
+		cmd.Stdin = strings.NewReader(value + "\n" + value + "\n")
+	} else {
+		// secret-tool reads the secret from stdin, keeping it out of the process list
+		cmd = exec.Command("secret-tool", "store", "--label", keychainService+": "+account, "service", keychainService, "account", account)
+		cmd.Stdin = strings.NewReader(value)
+	}
+	if _, err := exec.LookPath(cmd.Args[0]); err != nil {
+		return errKeychainUnavailable
+	}
+
+	var out bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &out, &out
+	if err := runWithTimeout(cmd, keychainTimeout); err != nil {
+		if msg := strings.TrimSpace(out.String()); msg != "" {
+			return fmt.Errorf("%s: %s", cmd.Args[0], msg)
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/nsvirk/totp-cli/config"
+	"github.com/nsvirk/totp-cli/totp"
+)
+
+// strictKeyLength turns the short key warning into an error, set by --strict
+var strictKeyLength bool
+
+// shortKeyMessage describes the problem with an entry whose secret decodes
+// to fewer than totp.MinKeyLength bytes, or returns "" when it's long enough
+// or doesn't decode at all (which is reported elsewhere)
+func shortKeyMessage(user string, entry config.Entry) string {
+	key, err := entry.Key()
+	if err != nil || len(key) >= totp.MinKeyLength {
+		return ""
+	}
+	whose := fmt.Sprintf("the secret of '%s'", user)
+	if entry.Rotation != "" {
+		whose = fmt.Sprintf("the %s secret of '%s'", entry.Rotation, user)
+	}
+	return fmt.Sprintf("%s is only %d byte(s) (%d bits), less than the %d byte minimum; it may have been cut off when pasting", whose, len(key), len(key)*8, totp.MinKeyLength)
+}
+
+// checkKeyLengths warns about the entries with a short key. In strict mode
+// it exits with exitInvalidSecret instead, before any code is generated.
+func checkKeyLengths(userIDs []string, entries []config.Entry) {
+	for i, entry := range entries {
+		message := shortKeyMessage(userIDs[i], entry)
+		if message == "" {
+			continue
+		}
+		if strictKeyLength {
+			failWith(exitInvalidSecret, []string{"user", userIDs[i]}, "Error: "+message)
+		}
+		warn(message)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/nsvirk/totp-cli/config"
+)
+
+// hotpHelperEnvVar makes the test binary act as one totp process generating
+// HOTP codes from the config file it names, see TestHOTPHelperProcess
+const hotpHelperEnvVar = "TOTP_TEST_HOTP_CONFIG"
+
+const (
+	hotpProcesses   = 8
+	hotpGenerations = 10
+)
+
+// TestHOTPHelperProcess isn't a test of its own: run by
+// TestConcurrentHOTPCounters, it generates codes and prints the counter of
+// each, one per line
+func TestHOTPHelperProcess(t *testing.T) {
+	configPath := os.Getenv(hotpHelperEnvVar)
+	if configPath == "" {
+		t.Skip("only runs as a helper process")
+	}
+	for range hotpGenerations {
+		_, counter, err := generateHOTP(configPath, "vpn", config.Entry{}, 0)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Println(counter)
+	}
+	os.Exit(0)
+}
+
+// TestConcurrentHOTPCounters runs several processes generating codes for the
+// same HOTP user at once. The config lock must give every generation its own
+// counter value, increasing within each process, and save the next one.
+func TestConcurrentHOTPCounters(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(configPath, []byte(`{"vpn": {"secret": "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ", "counter": 0}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var all []uint64
+	var wg sync.WaitGroup
+	for range hotpProcesses {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cmd := exec.Command(os.Args[0], "-test.run=^TestHOTPHelperProcess$")
+			cmd.Env = append(os.Environ(), hotpHelperEnvVar+"="+configPath)
+			var stderr bytes.Buffer
+			cmd.Stderr = &stderr
+			out, err := cmd.Output()
+			if err != nil {
+				t.Errorf("helper process: %v: %s", err, stderr.String())
+				return
+			}
+
+			var counters []uint64
+			scanner := bufio.NewScanner(bytes.NewReader(out))
+			for scanner.Scan() {
+				counter, err := strconv.ParseUint(scanner.Text(), 10, 64)
+				if err != nil {
+					t.Errorf("helper process printed %q", scanner.Text())
+					return
+				}
+				if len(counters) > 0 && counter <= counters[len(counters)-1] {
+					t.Errorf("counter went from %d to %d within one process", counters[len(counters)-1], counter)
+				}
+				counters = append(counters, counter)
+			}
+			mu.Lock()
+			all = append(all, counters...)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	total := hotpProcesses * hotpGenerations
+	if len(all) != total {
+		t.Fatalf("got %d counters, want %d", len(all), total)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i] < all[j] })
+	for i, counter := range all {
+		if counter != uint64(i) {
+			t.Fatalf("counters aren't 0-%d without gaps or repeats: got %d at position %d", total-1, counter, i)
+		}
+	}
+
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved struct {
+		VPN struct {
+			Counter uint64 `json:"counter"`
+		} `json:"vpn"`
+	}
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatal(err)
+	}
+	if saved.VPN.Counter != uint64(total) {
+		t.Errorf("saved counter is %d, want %d", saved.VPN.Counter, total)
+	}
+}
@@ -1,216 +1,2268 @@
 package main
 
 import (
-	"crypto/hmac"
-	"crypto/sha1"
-	"encoding/base32"
-	"encoding/binary"
+	"bufio"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
+	"net/url"
 	"os"
 	"os/exec"
+	"os/signal"
+	"path"
 	"path/filepath"
 	"runtime"
+	"sort"
+	"strconv"
 	"strings"
+	"syscall"
+	"text/template"
 	"time"
+
+	"github.com/nsvirk/totp-cli/config"
 )
 
-// Config represents the TOTP configuration
-type Config map[string]string
+// configEnvVar names the environment variable that overrides the config path
+const configEnvVar = "TOTP_CONFIG"
+
+// xdgConfigNames are the config files looked for in $XDG_CONFIG_HOME/totp-cli,
+// in order of precedence. The first is used when no config file exists yet.
+var xdgConfigNames = []string{"config.json", "config.yaml", "config.yml", "config.toml"}
+
+// xdgConfigDir is the directory under $XDG_CONFIG_HOME holding the config
+const xdgConfigDir = "totp-cli"
+
+// defaultConfigNames are the legacy config files looked for in the home
+// directory, after the XDG ones, in order of precedence
+var defaultConfigNames = []string{".totp_config.json", ".totp_config.yaml", ".totp_config.yml", ".totp_config.toml"}
+
+// resolveConfigPath picks the config file location. An explicit --config path
+// takes precedence over TOTP_CONFIG, which takes precedence over the default
+// $XDG_CONFIG_HOME/totp-cli/config.json or the legacy ~/.totp_config.json
+// (or .yaml, .yml or .toml). The second result reports whether the path was
+// given explicitly rather than defaulted.
+func resolveConfigPath(flagPath string) (string, bool, error) {
+	if flagPath != "" {
+		return flagPath, true, nil
+	}
+	if envPath := os.Getenv(configEnvVar); envPath != "" {
+		return envPath, true, nil
+	}
 
-// loadConfig loads the TOTP secrets from the config file
-func loadConfig() (Config, error) {
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
-		return nil, fmt.Errorf("could not get home directory: %v", err)
+		return "", false, fmt.Errorf("could not get home directory: %v", err)
+	}
+	return defaultConfigPath(homeDir), false, nil
+}
+
+// defaultConfigPath returns the highest precedence config file that exists,
+// the XDG ones before the legacy ones in homeDir, warning if others are
+// being ignored
+func defaultConfigPath(homeDir string) string {
+	xdgDir := filepath.Join(xdgConfigHome(homeDir), xdgConfigDir)
+	var candidates []string
+	for _, name := range xdgConfigNames {
+		candidates = append(candidates, filepath.Join(xdgDir, name))
+	}
+	for _, name := range defaultConfigNames {
+		candidates = append(candidates, filepath.Join(homeDir, name))
+	}
+
+	var found []string
+	for _, path := range candidates {
+		if _, err := os.Stat(path); err == nil {
+			found = append(found, path)
+		}
+	}
+	if len(found) == 0 {
+		return candidates[0]
+	}
+	for _, ignored := range found[1:] {
+		warn(fmt.Sprintf("Both %s and %s exist, ignoring %s", found[0], ignored, ignored))
+	}
+	return found[0]
+}
+
+// xdgConfigHome returns $XDG_CONFIG_HOME, or ~/.config when it's unset. The
+// spec says relative paths are invalid, so they're ignored too.
+func xdgConfigHome(homeDir string) string {
+	if dir := os.Getenv("XDG_CONFIG_HOME"); filepath.IsAbs(dir) {
+		return dir
+	}
+	return filepath.Join(homeDir, ".config")
+}
+
+// loadConfig loads the TOTP secrets from the config file at configPath and
+// the OS keychain
+func loadConfig(configPath string, explicit bool) (config.Config, error) {
+	raw, err := loadRawEntries(configPath)
+	if errors.Is(err, config.ErrNotFound) && !explicit {
+		return nil, fmt.Errorf("%w\nCreate a JSON file with format: {\"user_1\": \"totp_secret_1\", \"user_2\": \"totp_secret_2\"}", err)
+	}
+	if errors.Is(err, config.ErrEmpty) {
+		return nil, fmt.Errorf("%w\nAdd entries in the format {\"user_1\": \"totp_secret_1\", \"user_2\": \"totp_secret_2\"}, or with: totp add <user_id> <secret>", err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, err := config.ParseEntries(raw)
+	if err != nil {
+		return nil, err
+	}
+	for user, entry := range cfg {
+		cfg[user] = applyDefaults(entry)
+	}
+	return cfg, nil
+}
+
+// configSetting is the value of a reserved key like _default in one config file
+type configSetting struct {
+	path  string
+	value json.RawMessage
+}
+
+// configSettings maps each reserved key to its values in the config files,
+// in the order they're merged, so the last one wins
+type configSettings map[string][]configSetting
+
+// loadedSettings holds readSettings' result for each config path
+var loadedSettings = make(map[string]configSettings)
+
+// readSettings returns the reserved settings of the config file and the
+// extra config files, loaded once per run. Files that are missing or can't
+// be read are skipped.
+func readSettings(configPath string) configSettings {
+	if settings, ok := loadedSettings[configPath]; ok {
+		return settings
+	}
+	settings := make(configSettings)
+	for _, path := range append([]string{configPath}, extraConfigPaths...) {
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		file, err := readConfigFile(path)
+		if err != nil {
+			continue
+		}
+		for key, value := range file.reserved {
+			settings[key] = append(settings[key], configSetting{path, value})
+		}
+	}
+	loadedSettings[configPath] = settings
+	return settings
+}
+
+// defaultUser returns the user named by the _default key of the config
+// file, or of the last extra config file that has one, and "" if none does
+func defaultUser(settings configSettings) string {
+	user := ""
+	for _, setting := range settings[config.DefaultKey] {
+		if err := json.Unmarshal(setting.value, &user); err != nil {
+			warn(fmt.Sprintf("Ignoring %s in %s: it must be a user ID", config.DefaultKey, setting.path))
+		}
+	}
+	return user
+}
+
+// configFile is a config file as read in this run: its users, flattened to
+// "group/name" keys, and its comments and settings
+type configFile struct {
+	raw      map[string]json.RawMessage
+	reserved map[string]json.RawMessage
+}
+
+// configFiles caches readConfigFile, so each file is read, decrypted and
+// decoded once however many settings and users are looked up in it.
+// updateConfigFile drops the files it rewrites.
+var configFiles = make(map[string]configFile)
+
+// readConfigFile reads and decodes a config file, or returns it from
+// configFiles. The maps are shared and mustn't be modified.
+func readConfigFile(configPath string) (configFile, error) {
+	if file, ok := configFiles[configPath]; ok {
+		return file, nil
+	}
+	data, _, err := readConfigData(configPath)
+	if err != nil {
+		return configFile{}, err
+	}
+	raw, reserved, err := config.ParseRawSettings(data, config.FormatOf(configPath))
+	if err != nil {
+		return configFile{}, err
+	}
+	file := configFile{raw: raw, reserved: reserved}
+	configFiles[configPath] = file
+	return file, nil
+}
+
+// readRawConfig reads the config file as a map of unparsed entries, with
+// grouped users flattened to "group/name" keys. The map is shared and
+// mustn't be modified.
+func readRawConfig(configPath string) (map[string]json.RawMessage, error) {
+	file, err := readConfigFile(configPath)
+	return file.raw, err
+}
+
+// entryCode generates a time-based entry's code for the current time step
+// moved by stepOffset steps (-1 for the previous code, 1 for the next)
+func entryCode(entry config.Entry, stepOffset int64) (string, error) {
+	return entry.Code(time.Unix(now()+stepOffset*int64(entry.Options().Period), 0))
+}
+
+// parseSecret turns a secret given outside the config file into an entry,
+// with any settings from the command line applied, and makes sure it can
+// produce a code. HOTP isn't allowed since there would be nowhere to save
+// the counter.
+func parseSecret(secret string, overrides config.Entry) (config.Entry, error) {
+	value, err := config.Marshal(strings.TrimSpace(secret), "")
+	if err != nil {
+		return config.Entry{}, err
+	}
+	entry, err := parseEntry(value)
+	if err != nil {
+		return config.Entry{}, err
+	}
+	if entry, err = applyOverrides(entry, overrides); err != nil {
+		return config.Entry{}, err
+	}
+	if entry.IsHOTP() {
+		return config.Entry{}, fmt.Errorf("HOTP secrets need a config file to keep their counter")
+	}
+	return entry, entry.Check()
+}
+
+// applyOverrides replaces the entry's settings with those given on the
+// command line, so flags take precedence over per-user settings, which take
+// precedence over the defaults
+func applyOverrides(entry config.Entry, overrides config.Entry) (config.Entry, error) {
+	if overrides.Algorithm != "" {
+		entry.Algorithm = overrides.Algorithm
+	}
+	if overrides.Digits != 0 {
+		entry.Digits = overrides.Digits
+	}
+	if overrides.Period != 0 {
+		entry.Period = overrides.Period
+	}
+	if err := entry.Validate(); err != nil {
+		return config.Entry{}, err
+	}
+	return entry, nil
+}
+
+// readStdinSecret reads a secret from the first non-empty line of stdin
+func readStdinSecret() (string, error) {
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			return line, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("no secret found on stdin")
+}
+
+// checkResult is the outcome of checking a single config entry
+type checkResult struct {
+	User    string `json:"user"`
+	Valid   bool   `json:"valid"`
+	Error   string `json:"error,omitempty"`
+	Warning string `json:"warning,omitempty"`
+}
+
+// checkConfig tries to parse every entry and generate a code from it,
+// without copying anything or advancing HOTP counters
+func checkConfig(configPath string) ([]checkResult, error) {
+	raw, err := loadRawEntries(configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	users := make([]string, 0, len(raw))
+	for user := range raw {
+		users = append(users, user)
+	}
+	sort.Strings(users)
+
+	results := make([]checkResult, 0, len(users))
+	for _, user := range users {
+		result := checkResult{User: user, Valid: true}
+		entry, err := parseEntry(raw[user])
+		if err == nil {
+			err = entry.Check()
+		}
+		if err != nil {
+			result.Valid = false
+			result.Error = err.Error()
+		} else {
+			// Keys that work but are suspiciously short, an error with --strict
+			for _, rotated := range entry.Rotated() {
+				if message := shortKeyMessage(user, rotated); message != "" {
+					if strictKeyLength {
+						result.Valid, result.Error = false, message
+					} else {
+						result.Warning = message
+					}
+					break
+				}
+			}
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// timeOffset is added to the system clock for TOTP generation, set by
+// --time-offset to compensate for clock skew
+var timeOffset int64
+
+// atTime replaces the system clock for TOTP generation when set by --at, to
+// reproduce the code of a given moment
+var atTime *time.Time
+
+// now returns the current Unix time in seconds, or the --at time, adjusted
+// by timeOffset
+func now() int64 {
+	t := time.Now()
+	if atTime != nil {
+		t = *atTime
+	}
+	return t.Unix() + timeOffset
+}
+
+// parseAtTime parses an --at value: an RFC 3339 time or Unix seconds. TOTP
+// counts time steps from the Unix epoch, so earlier times have no code.
+func parseAtTime(value string) (time.Time, error) {
+	var t time.Time
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		t = time.Unix(seconds, 0)
+	} else if t, err = time.Parse(time.RFC3339, value); err != nil {
+		return time.Time{}, fmt.Errorf("Invalid --at value '%s': must be an RFC 3339 time like 2024-01-02T15:04:05Z or Unix seconds", value)
+	}
+	if t.Unix() < 0 {
+		return time.Time{}, fmt.Errorf("Invalid --at value '%s': must not be before 1970-01-01T00:00:00Z (Unix time 0), where TOTP time steps start", value)
+	}
+	return t, nil
+}
+
+// generateHOTP generates a counter-based HOTP code for the user, then
+// persists the incremented counter back to the config file. The config is
+// re-read under a lock so concurrent invocations never reuse a counter.
+// Settings from the command line are applied to the re-read entry, and
+// advance skips that many counter values first, to resync with a server.
+func generateHOTP(configPath, user string, overrides config.Entry, advance uint64) (string, uint64, error) {
+	var code string
+	var counter uint64
+	configPath = configFileOf(configPath, user)
+	err := updateRawConfig(configPath, false, func(raw map[string]json.RawMessage) error {
+		key, ok := findKey(raw, user)
+		if !ok {
+			return fmt.Errorf("user '%s' disappeared from the config file", user)
+		}
+		entry, err := parseEntry(raw[key])
+		if err != nil {
+			return fmt.Errorf("invalid entry for user '%s': %v", key, err)
+		}
+		if !entry.IsHOTP() {
+			return fmt.Errorf("user '%s' is no longer an HOTP entry", key)
+		}
+		if entry, err = applyOverrides(entry, overrides); err != nil {
+			return fmt.Errorf("invalid settings for user '%s': %v", key, err)
+		}
+
+		if *entry.Counter > math.MaxUint64-1-advance {
+			return fmt.Errorf("advancing the counter of user '%s' by %d would overflow it", key, advance)
+		}
+		counter = *entry.Counter + advance
+		entry.Counter = &counter
+		code, err = entry.Code(time.Now())
+		if err != nil {
+			return err
+		}
+
+		updated, err := setCounter(raw[key], counter+1)
+		if err != nil {
+			return fmt.Errorf("could not update counter for user '%s': %v", key, err)
+		}
+		raw[key] = updated
+		return nil
+	})
+	if err != nil {
+		return "", 0, err
+	}
+	return code, counter, nil
+}
+
+// configFileOf returns the config file that the user's entry comes from:
+// the last of the extra config files holding it, or else configPath
+func configFileOf(configPath, user string) string {
+	for i := len(extraConfigPaths) - 1; i >= 0; i-- {
+		raw, err := readRawConfig(extraConfigPaths[i])
+		if err != nil {
+			continue
+		}
+		if _, ok := findKey(raw, user); ok {
+			return extraConfigPaths[i]
+		}
+	}
+	return configPath
+}
+
+// updateRawConfig applies a change to the config file under a lock. The
+// file is re-read, passed to update, and written back atomically with
+// sorted keys, keeping its format, encryption, groups and _comment keys.
+// YAML and TOML files with # comments are refused rather than rewritten
+// without them. update sees grouped users under their flattened
+// "group/name" keys, and no comments or settings. With create set, a
+// missing file is treated as an empty config.
+func updateRawConfig(configPath string, create bool, update func(raw map[string]json.RawMessage) error) error {
+	return updateConfigFile(configPath, create, func(raw, _ map[string]json.RawMessage) error {
+		return update(raw)
+	})
+}
+
+// updateConfigFile is updateRawConfig for the changes that also need the
+// comments and settings like _default, which update gets as reserved
+func updateConfigFile(configPath string, create bool, update func(raw, reserved map[string]json.RawMessage) error) error {
+	if create {
+		// The default config lives in its own directory, which may not exist yet
+		if err := os.MkdirAll(filepath.Dir(configPath), 0700); err != nil {
+			return err
+		}
+	}
+	unlock, err := lockConfig(configPath)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	raw := make(map[string]json.RawMessage)
+	groups := make(map[string]string)
+	var passphrase string
+	if _, err := os.Stat(configPath); err == nil || !create {
+		var data []byte
+		data, passphrase, err = readConfigData(configPath)
+		if err != nil {
+			return err
+		}
+		format := config.FormatOf(configPath)
+		if raw, err = config.Decode(data, format); err != nil {
+			return err
+		}
+		if config.HasComments(data, format) {
+			return fmt.Errorf("%s has # comments, which writing to it would drop; edit it by hand, or turn them into _comment keys", configPath)
+		}
+		raw, groups, err = config.Flatten(raw)
+		if err != nil {
+			return fmt.Errorf("invalid config file: %v", err)
+		}
+	}
+
+	// Comments and settings aren't users, but they're written back untouched
+	reserved := config.StripReserved(raw)
+	if err := update(raw, reserved); err != nil {
+		return err
+	}
+	for key, value := range reserved {
+		raw[key] = value
+	}
+
+	nested, err := config.Nest(raw, groups)
+	if err != nil {
+		return err
+	}
+	out, err := config.Encode(nested, config.FormatOf(configPath))
+	if err != nil {
+		return err
+	}
+	if err := writeConfigData(configPath, out, passphrase); err != nil {
+		return fmt.Errorf("could not save config file: %v", err)
+	}
+	delete(configFiles, configPath)
+	clear(loadedSettings)
+	return nil
+}
+
+// findKey looks up a config key case-insensitively, preferring an exact
+// match when several keys differ only by case
+func findKey(raw map[string]json.RawMessage, user string) (string, bool) {
+	if _, exists := raw[user]; exists {
+		return user, true
+	}
+	for key := range raw {
+		if strings.EqualFold(key, user) {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+// setCounter rewrites a raw config value with a new HOTP counter. Object
+// entries get their "counter" field replaced, while otpauth://hotp/ URI
+// strings get their counter query parameter updated.
+func setCounter(value json.RawMessage, counter uint64) (json.RawMessage, error) {
+	var uri string
+	if err := json.Unmarshal(value, &uri); err == nil {
+		u, err := url.Parse(uri)
+		if err != nil {
+			return nil, err
+		}
+		query := u.Query()
+		query.Set("counter", strconv.FormatUint(counter, 10))
+		u.RawQuery = query.Encode()
+		return config.Marshal(u.String(), "")
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(value, &fields); err != nil {
+		return nil, err
+	}
+	fields["counter"] = json.RawMessage(strconv.FormatUint(counter, 10))
+	return config.Marshal(fields, "")
+}
+
+// lockConfig takes an exclusive lock on the config file, an flock (or
+// LockFileEx on Windows) on a lock file next to it, waiting briefly for other
+// invocations to release theirs. The OS drops the lock if the process dies,
+// so the lock file left behind is harmless. The returned function releases
+// the lock.
+func lockConfig(configPath string) (func(), error) {
+	lockPath := configPath + ".lock"
+	f, err := os.OpenFile(lockPath, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("could not lock config file: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		locked, err := tryLockFile(f)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("could not lock config file: %v", err)
+		}
+		if locked {
+			return func() {
+				unlockFile(f)
+				f.Close()
+			}, nil
+		}
+		if time.Now().After(deadline) {
+			f.Close()
+			return nil, fmt.Errorf("timed out waiting for the lock on %s (another totp is still using it)", lockPath)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// writeFileAtomic replaces a file by writing to a temporary file in the same
+// directory and renaming it into place, so a crash never leaves it truncated
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath)
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// secondsRemaining returns how many seconds are left in the current time step
+func secondsRemaining(period int) int {
+	return period - int(now()%int64(period))
+}
+
+// defaultExpiryWarning is how many seconds before expiry a code is considered
+// too close to expiring to be useful, see --expiry-warning
+const defaultExpiryWarning = 5
+
+// untilNextStep returns how long until the next time step of period begins
+func untilNextStep(period int) time.Duration {
+	t := time.Now().Add(time.Duration(timeOffset) * time.Second)
+	next := (t.Unix()/int64(period) + 1) * int64(period)
+	return time.Unix(next, 0).Sub(t)
+}
+
+// jsonOutput switches stdout and error reporting to JSON, set by --json
+var jsonOutput bool
+
+// codeOutput is the JSON form of a generated code
+type codeOutput struct {
+	User      string  `json:"user"`
+	Code      string  `json:"code"`
+	ExpiresIn int     `json:"expires_in,omitempty"`
+	Elapsed   *int    `json:"elapsed,omitempty"`
+	Period    int     `json:"period,omitempty"`
+	Counter   *uint64 `json:"counter,omitempty"`
+	Previous  string  `json:"previous,omitempty"`
+	Next      string  `json:"next,omitempty"`
+	Rotation  string  `json:"rotation,omitempty"`
+}
+
+// errorOutput is the JSON form of an error or warning written to stderr
+type errorOutput struct {
+	Error   string `json:"error,omitempty"`
+	Warning string `json:"warning,omitempty"`
+	// Context holds the error's fields, like "user", see failWith
+	Context map[string]string `json:"context,omitempty"`
+	Hints   []string          `json:"hints,omitempty"`
+}
+
+// printJSON writes a value as a single line of JSON
+func printJSON(w *os.File, v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "⚠️ Error encoding JSON: %v\n", err)
+		return
+	}
+	fmt.Fprintln(w, string(data))
+}
+
+// Exit codes, so wrappers can tell failures apart without parsing stderr
+const (
+	exitError         = 1 // general failure, including usage errors
+	exitUserNotFound  = 2 // unknown or ambiguous user ID
+	exitConfigMissing = 3 // config file doesn't exist
+	exitInvalidSecret = 4 // secret or entry settings can't produce a code
+	exitClipboard     = 5 // the code was generated but couldn't be copied
+	exitNoMatch       = 6 // verify found no matching code
+)
+
+// configExitCode picks the exit code for an error returned by loadConfig
+func configExitCode(err error) int {
+	switch {
+	case errors.Is(err, config.ErrNotFound), errors.Is(err, config.ErrEmpty):
+		return exitConfigMissing
+	case errors.Is(err, config.ErrInvalidEntry):
+		return exitInvalidSecret
+	default:
+		return exitError
+	}
+}
+
+// porcelainOutput switches diagnostics on stderr to stable, prefix-tagged
+// lines for tools, set by --porcelain. It takes precedence over --json for
+// stderr only.
+var porcelainOutput bool
+
+// exitKinds names the exit codes in --porcelain error lines
+var exitKinds = map[int]string{
+	exitError:         "error",
+	exitUserNotFound:  "user_not_found",
+	exitConfigMissing: "config_missing",
+	exitInvalidSecret: "invalid_secret",
+	exitClipboard:     "clipboard",
+	exitNoMatch:       "no_match",
+}
+
+// fatal reports an error with optional hint lines on stderr and exits with
+// the general failure code
+func fatal(message string, hints ...string) {
+	fail(exitError, message, hints...)
+}
+
+// fail reports an error with optional hint lines on stderr and exits with code
+func fail(code int, message string, hints ...string) {
+	failWith(code, nil, message, hints...)
+}
+
+// failWith is fail with the error's context as alternating keys and values,
+// like []string{"user", userID}, which --porcelain and --json give as fields
+// so tools don't have to pick them out of the message
+func failWith(code int, context []string, message string, hints ...string) {
+	if porcelainOutput {
+		kind, ok := exitKinds[code]
+		if !ok {
+			kind = exitKinds[exitError]
+		}
+		fmt.Fprintf(os.Stderr, "error: %s%s msg=%s\n", kind, porcelainFields(context), strconv.Quote(strings.TrimPrefix(message, "Error: ")))
+		for _, hint := range hints {
+			fmt.Fprintf(os.Stderr, "hint: msg=%s\n", strconv.Quote(hint))
+		}
+	} else if jsonOutput {
+		printJSON(os.Stderr, errorOutput{Error: message, Context: contextMap(context), Hints: hints})
+	} else {
+		fmt.Fprintf(os.Stderr, "⚠️ %s\n", message)
+		for _, hint := range hints {
+			fmt.Fprintf(os.Stderr, "⚠️ %s\n", hint)
+		}
+	}
+	os.Exit(code)
+}
+
+// porcelainFields formats an error's context as " key=value" pairs, quoting
+// the values that aren't a single plain word
+func porcelainFields(context []string) string {
+	var b strings.Builder
+	for i := 0; i+1 < len(context); i += 2 {
+		value := context[i+1]
+		if value == "" || strings.IndexFunc(value, func(r rune) bool {
+			return r <= ' ' || r == '"' || r == '=' || r == '\\' || r > '~'
+		}) >= 0 {
+			value = strconv.Quote(value)
+		}
+		fmt.Fprintf(&b, " %s=%s", context[i], value)
+	}
+	return b.String()
+}
+
+// contextMap turns an error's context into the "context" object of --json
+func contextMap(context []string) map[string]string {
+	if len(context) < 2 {
+		return nil
+	}
+	fields := make(map[string]string, len(context)/2)
+	for i := 0; i+1 < len(context); i += 2 {
+		fields[context[i]] = context[i+1]
+	}
+	return fields
+}
+
+// warn reports a non-fatal problem on stderr
+func warn(message string) {
+	if porcelainOutput {
+		fmt.Fprintf(os.Stderr, "warning: msg=%s\n", strconv.Quote(message))
+	} else if jsonOutput {
+		printJSON(os.Stderr, errorOutput{Warning: message})
+	} else {
+		fmt.Fprintf(os.Stderr, "⚠️ Warning: %s\n", message)
+	}
+}
+
+// progressBarWidth is the number of characters in the watch mode progress bar
+const progressBarWidth = 20
+
+// progressBar draws how much of the time step has elapsed, filling up as the
+// code gets closer to expiring
+func progressBar(remaining, period int) string {
+	filled := (period - remaining) * progressBarWidth / period
+	return strings.Repeat("█", filled) + strings.Repeat("░", progressBarWidth-filled)
+}
+
+// groupDigits splits displayed codes in two halves, like "123 456", set by
+// --group. Copied and JSON codes are never grouped.
+var groupDigits bool
+
+// displayCode formats a code for reading, grouping it if groupDigits is set.
+// Odd lengths put the longer half last: "123 4567".
+func displayCode(code string) string {
+	if !groupDigits || len(code) < 4 {
+		return code
+	}
+	half := len(code) / 2
+	return code[:half] + " " + code[half:]
+}
+
+// writeCodes writes codes to path one per line, for --out. New files are
+// only readable by the user. A named pipe is written to as is, which waits
+// for a reader to open it.
+func writeCodes(path string, codes []string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(strings.Join(codes, "\n") + "\n"); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// watchCode prints the code for a user and regenerates it whenever the time
+// step rolls over, showing a live countdown until interrupted
+func watchCode(userID string, entry config.Entry, copyToClip bool) error {
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupt)
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	period := entry.Options().Period
+	lastStep := int64(-1)
+	for {
+		step := now() / int64(period)
+		if step != lastStep {
+			code, err := entryCode(entry, 0)
+			if err != nil {
+				return err
+			}
+			lastStep = step
+
+			copied := false
+			if copyToClip {
+				if err := copyToClipboard(code); err != nil {
+					warn(fmt.Sprintf("Could not copy to clipboard: %v", err))
+				} else {
+					copied = true
+				}
+			}
+
+			if jsonOutput {
+				// Emit one JSON object per time step instead of redrawing
+				printJSON(os.Stdout, codeOutput{User: userID, Code: code, ExpiresIn: secondsRemaining(period)})
+			} else {
+				// Clear the screen and redraw for the new time step
+				fmt.Print("\033[H\033[2J")
+				fmt.Println(formatField("👤", "User", colorize(ansiCyan, entry.DisplayName(userID))))
+				fmt.Println(formatField("🔑", "TOTP Code", colorize(ansiGreen, displayCode(code))))
+				if copied {
+					fmt.Println(copiedMessage())
+				}
+			}
+		}
+		if !jsonOutput {
+			remaining := secondsRemaining(period)
+			countdown := colorize(expiryColor(remaining), fmt.Sprintf("%2ds  %s", remaining, progressBar(remaining, period)))
+			fmt.Printf("\r%s\033[K", formatField("⏳", "Expires in", countdown))
+		}
+
+		select {
+		case <-interrupt:
+			if !jsonOutput {
+				// Erase the countdown so only the code is left on screen
+				fmt.Print("\r\033[K")
+			}
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// isWSL reports whether we're running under the Windows Subsystem for Linux
+func isWSL() bool {
+	data, err := os.ReadFile("/proc/version")
+	if err != nil {
+		return false
+	}
+	return strings.Contains(strings.ToLower(string(data)), "microsoft")
+}
+
+// sshWithoutDisplay reports whether we're in an SSH session with no X11 or
+// Wayland display, where the local clipboard tools write to a clipboard on
+// this machine that the user can't paste from. A custom clipboard command is
+// trusted to know better.
+func sshWithoutDisplay() bool {
+	if command, _ := customClipboardCmd(); os.Getenv("SSH_CONNECTION") == "" || command != "" {
+		return false
+	}
+	return os.Getenv("DISPLAY") == "" && os.Getenv("WAYLAND_DISPLAY") == ""
+}
+
+// copyPrimary also copies codes to the PRIMARY selection on Linux, which
+// middle-click pastes from, set by --primary
+var copyPrimary bool
+
+// clipboardCmdEnvVar names the environment variable holding a custom
+// clipboard command, which replaces the built-in detection
+const clipboardCmdEnvVar = "TOTP_CLIPBOARD_CMD"
+
+// configClipboardCmd and configNoClipboardHosts are the _clipboard_cmd and
+// _no_clipboard_hosts of the config, loaded by loadClipboardSettings
+var (
+	configClipboardCmd     string
+	configNoClipboardHosts []string
+)
+
+// loadClipboardSettings takes the clipboard settings from those of the
+// config files, before anything is copied
+func loadClipboardSettings(settings configSettings) {
+	for _, setting := range settings[config.ClipboardCmdKey] {
+		if err := json.Unmarshal(setting.value, &configClipboardCmd); err != nil {
+			warn(fmt.Sprintf("Ignoring %s in %s: it must be a command line", config.ClipboardCmdKey, setting.path))
+		}
+	}
+	for _, setting := range settings[config.NoClipboardHostsKey] {
+		// A list of hostnames, or a comma-separated string like the
+		// environment variable
+		var hosts []string
+		var list string
+		if err := json.Unmarshal(setting.value, &hosts); err == nil {
+			configNoClipboardHosts = hosts
+		} else if err := json.Unmarshal(setting.value, &list); err == nil {
+			configNoClipboardHosts = strings.Split(list, ",")
+		} else {
+			warn(fmt.Sprintf("Ignoring %s in %s: it must be a list of hostnames", config.NoClipboardHostsKey, setting.path))
+		}
+	}
+}
+
+// customClipboardCmd returns the clipboard command that replaces the
+// built-in detection, and where it's set: TOTP_CLIPBOARD_CMD, or else the
+// config's _clipboard_cmd. Both are "" when neither is set.
+func customClipboardCmd() (string, string) {
+	if command := strings.TrimSpace(os.Getenv(clipboardCmdEnvVar)); command != "" {
+		return command, clipboardCmdEnvVar
+	}
+	if command := strings.TrimSpace(configClipboardCmd); command != "" {
+		return command, config.ClipboardCmdKey
+	}
+	return "", ""
+}
+
+// Environment variables that turn clipboard copying off by default, for
+// shared machines like jump hosts. TOTP_NO_CLIPBOARD_HOSTS is a
+// comma-separated list of hostnames, which may use * wildcards.
+const (
+	noClipboardEnvVar      = "TOTP_NO_CLIPBOARD"
+	noClipboardHostsEnvVar = "TOTP_NO_CLIPBOARD_HOSTS"
+)
+
+// clipboardDisabled reports whether the environment or the config turns
+// clipboard copying off on this machine, and which variable or key does
+func clipboardDisabled() (string, bool) {
+	if value := os.Getenv(noClipboardEnvVar); value != "" && value != "0" && !strings.EqualFold(value, "false") {
+		return noClipboardEnvVar, true
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "", false
+	}
+	if hostMatches(hostname, strings.Split(os.Getenv(noClipboardHostsEnvVar), ",")) {
+		return noClipboardHostsEnvVar, true
+	}
+	if hostMatches(hostname, configNoClipboardHosts) {
+		return config.NoClipboardHostsKey, true
+	}
+	return "", false
+}
+
+// hostMatches reports whether a hostname matches one of the patterns,
+// ignoring case
+func hostMatches(hostname string, patterns []string) bool {
+	for _, pattern := range patterns {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		if matched, _ := path.Match(strings.ToLower(pattern), strings.ToLower(hostname)); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// copyToClipboard copies text to the system clipboard, and to the primary
+// selection if copyPrimary is set
+func copyToClipboard(text string) error {
+	if usingOSC52() {
+		return copyOSC52(text)
+	}
+	cmd, err := clipboardCommand()
+	if err != nil {
+		return err
+	}
+
+	err = runClipboardCommand(cmd, text)
+	_, source := customClipboardCmd()
+	if err != nil && cmd.Args[0] != "cmd" && runtime.GOOS == "windows" && source == "" {
+		// PowerShell can be missing or blocked by policy, clip is always there
+		err = runClipboardCommand(exec.Command("cmd", "/c", "clip"), text)
+	}
+	if err != nil {
+		if source != "" {
+			return fmt.Errorf("%s command '%s': %v", source, strings.Join(cmd.Args, " "), err)
+		}
+		return err
+	}
+	if copyPrimary {
+		return copyToPrimary(text)
+	}
+	return nil
+}
+
+// clipboardCommand returns the command that copies its stdin to the
+// clipboard: the one in TOTP_CLIPBOARD_CMD or _clipboard_cmd, split on
+// spaces, or else the platform's usual tool
+func clipboardCommand() (*exec.Cmd, error) {
+	if command, _ := customClipboardCmd(); command != "" {
+		fields := strings.Fields(command)
+		return exec.Command(fields[0], fields[1:]...), nil
+	}
+
+	switch runtime.GOOS {
+	case "darwin": // macOS
+		return exec.Command("pbcopy"), nil
+	case "linux":
+		// Use the Windows clipboard under WSL, prefer wl-copy on Wayland,
+		// then fall back to xclip and xsel
+		if _, err := exec.LookPath("clip.exe"); err == nil && isWSL() {
+			return exec.Command("clip.exe"), nil
+		} else if _, err := exec.LookPath("wl-copy"); err == nil && os.Getenv("WAYLAND_DISPLAY") != "" {
+			return exec.Command("wl-copy"), nil
+		} else if _, err := exec.LookPath("xclip"); err == nil {
+			return exec.Command("xclip", "-selection", "clipboard"), nil
+		} else if _, err := exec.LookPath("xsel"); err == nil {
+			return exec.Command("xsel", "--clipboard", "--input"), nil
+		}
+		return nil, fmt.Errorf("no clipboard utility found (install wl-clipboard, xclip, or xsel, or set %s)", clipboardCmdEnvVar)
+	case "windows":
+		// Prefer PowerShell's Set-Clipboard, which handles Unicode properly,
+		// over clip
+		if path, err := exec.LookPath("powershell.exe"); err == nil {
+			return exec.Command(path, "-NoProfile", "-NonInteractive", "-Command", setClipboardScript), nil
+		}
+		return exec.Command("cmd", "/c", "clip"), nil
+	default:
+		return nil, fmt.Errorf("clipboard not supported on %s (set %s to a command that reads the code from stdin)", runtime.GOOS, clipboardCmdEnvVar)
+	}
+}
+
+// setClipboardScript copies PowerShell's stdin to the Windows clipboard, or
+// clears it when stdin is empty
+const setClipboardScript = "$text = [Console]::In.ReadToEnd(); if ($text) { Set-Clipboard -Value $text } else { Set-Clipboard -Value $null }"
+
+// copyToPrimary copies text to the X11 or Wayland PRIMARY selection
+func copyToPrimary(text string) error {
+	var cmd *exec.Cmd
+	if _, err := exec.LookPath("wl-copy"); err == nil && os.Getenv("WAYLAND_DISPLAY") != "" {
+		cmd = exec.Command("wl-copy", "--primary")
+	} else if _, err := exec.LookPath("xclip"); err == nil {
+		cmd = exec.Command("xclip", "-selection", "primary")
+	} else if _, err := exec.LookPath("xsel"); err == nil {
+		cmd = exec.Command("xsel", "--primary", "--input")
+	} else {
+		return fmt.Errorf("no utility found for the primary selection (install wl-clipboard, xclip, or xsel)")
+	}
+
+	if err := runClipboardCommand(cmd, text); err != nil {
+		return fmt.Errorf("primary selection: %v", err)
+	}
+	return nil
+}
+
+// clipboardTimeout bounds how long a clipboard command may take, so a hung
+// helper (e.g. xclip talking to a stuck X server) can't freeze the CLI
+const clipboardTimeout = 3 * time.Second
+
+// runClipboardCommand runs a clipboard command with text on stdin, killing
+// it if it hasn't finished within clipboardTimeout
+func runClipboardCommand(cmd *exec.Cmd, text string) error {
+	cmd.Stdin = strings.NewReader(text)
+	return runWithTimeout(cmd, clipboardTimeout)
+}
+
+// runWithTimeout runs a helper command, killing it if it hasn't finished
+// within timeout
+func runWithTimeout(cmd *exec.Cmd, timeout time.Duration) error {
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(timeout):
+		cmd.Process.Kill()
+		return fmt.Errorf("%s didn't finish within %v and was stopped", cmd.Args[0], timeout)
+	}
+}
+
+// clearClipboardCommand is the hidden argument that runs the background
+// process started by scheduleClipboardClear
+const clearClipboardCommand = "__clear-clipboard"
+
+// clipboardClear is what the background clearing process needs to know,
+// passed on its stdin so the code and clipboard contents never show up in
+// the process list
+type clipboardClear struct {
+	// Code is what was copied, so the clipboard is left alone if something
+	// else has been copied since
+	Code string `json:"code"`
+	// Previous is the clipboard content before the code, restored instead
+	// of clearing when Restore is set
+	Previous string `json:"previous,omitempty"`
+	Restore  bool   `json:"restore,omitempty"`
+}
+
+// scheduleClipboardClear starts a background copy of this program that
+// clears the clipboard after delay seconds, or puts back its previous
+// contents, so the caller doesn't have to wait
+func scheduleClipboardClear(delay int, clear clipboardClear) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("could not locate executable: %v", err)
 	}
-
-	configPath := filepath.Join(homeDir, ".totp_config.json")
-
-	if _, err := os.Stat(configPath); os.IsNotExist(err) {
-		return nil, fmt.Errorf("config file not found: %s\nCreate a JSON file with format: {\"user_1\": \"totp_secret_1\", \"user_2\": \"totp_secret_2\"}", configPath)
+	data, err := json.Marshal(clear)
+	if err != nil {
+		return err
 	}
 
-	data, err := os.ReadFile(configPath)
+	// The child is intentionally not waited on; it outlives this process.
+	// Its stdin is a pipe written here directly, so it's complete before
+	// this process exits.
+	args := []string{clearClipboardCommand, strconv.Itoa(delay)}
+	if copyPrimary {
+		args = append(args, "--primary")
+	}
+	if osc52Copy {
+		args = append(args, "--osc52")
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	cmd := exec.Command(exe, args...)
+	cmd.Stdin = r
+	if command, source := customClipboardCmd(); source == config.ClipboardCmdKey {
+		// The child doesn't read the config, so it gets the command the way
+		// it would have been given in the environment
+		cmd.Env = append(os.Environ(), clipboardCmdEnvVar+"="+command)
+	}
+	err = cmd.Start()
+	r.Close()
 	if err != nil {
-		return nil, fmt.Errorf("error reading config file: %v", err)
+		return err
 	}
+	_, err = w.Write(data)
+	return err
+}
 
-	var config Config
-	if err := json.Unmarshal(data, &config); err != nil {
-		return nil, fmt.Errorf("invalid JSON in config file: %v", err)
+// runClipboardClear waits for the given number of seconds and then restores
+// or clears the clipboard, and clears the primary selection if it was copied
+// to as well. Nothing is touched if the clipboard no longer holds the code.
+// The flags are --primary and --osc52, passed on from the invocation that
+// copied.
+func runClipboardClear(delayArg string, flags []string) {
+	primary := false
+	for _, flag := range flags {
+		switch flag {
+		case "--primary":
+			primary = true
+		case "--osc52":
+			osc52Copy = true
+		}
+	}
+	delay, err := strconv.Atoi(delayArg)
+	if err != nil || delay <= 0 {
+		os.Exit(1)
 	}
+	var clear clipboardClear
+	if err := json.NewDecoder(os.Stdin).Decode(&clear); err != nil {
+		os.Exit(1)
+	}
+	time.Sleep(time.Duration(delay) * time.Second)
 
-	return config, nil
+	// The terminal's clipboard can't be read back, so it's always cleared
+	if usingOSC52() {
+		copyPrimary = primary
+		copyToClipboard("")
+		return
+	}
+	if current, ok := readClipboard(); ok && current != clear.Code {
+		return
+	}
+	text := ""
+	if clear.Restore {
+		text = clear.Previous
+	}
+	if err := copyToClipboard(text); err != nil {
+		os.Exit(1)
+	}
+	if primary {
+		if err := copyToPrimary(""); err != nil {
+			os.Exit(1)
+		}
+	}
 }
 
-// generateTOTP generates a TOTP code from a base32 secret
-func generateTOTP(secret string) (string, error) {
-	// Remove any whitespace and convert to uppercase
-	secret = strings.ToUpper(strings.ReplaceAll(secret, " ", ""))
+// createCaseInsensitiveMap maps lowercased user IDs to their config keys for
+// case-insensitive lookup. User IDs that differ only by case collide: the
+// first in sorted order wins, and the groups of colliding keys are returned
+// so the caller can report them.
+func createCaseInsensitiveMap(cfg config.Config) (map[string]string, [][]string) {
+	caseInsensitiveMap := make(map[string]string, len(cfg))
+	byLower := make(map[string][]string)
+	for _, key := range sortedUsers(cfg) {
+		lower := strings.ToLower(key)
+		if _, exists := caseInsensitiveMap[lower]; !exists {
+			caseInsensitiveMap[lower] = key
+		}
+		byLower[lower] = append(byLower[lower], key)
+	}
 
-	// Decode base32 secret
-	key, err := base32.StdEncoding.DecodeString(secret)
-	if err != nil {
-		return "", fmt.Errorf("invalid base32 secret: %v", err)
+	var collisions [][]string
+	for _, key := range sortedUsers(cfg) {
+		if keys := byLower[strings.ToLower(key)]; len(keys) > 1 && keys[0] == key {
+			collisions = append(collisions, keys)
+		}
 	}
+	return caseInsensitiveMap, collisions
+}
 
-	// Get current time step (30-second intervals)
-	timeStep := time.Now().Unix() / 30
+// reportCaseCollisions warns about user IDs that differ only by case, since
+// all but one of them can only be reached by their exact spelling. In strict
+// mode it's an error instead.
+func reportCaseCollisions(collisions [][]string, strict bool) {
+	for _, keys := range collisions {
+		quoted := make([]string, len(keys))
+		for i, key := range keys {
+			quoted[i] = "'" + key + "'"
+		}
+		names := strings.Join(quoted[:len(quoted)-1], ", ") + " and " + quoted[len(quoted)-1]
+		if strict {
+			fatal(fmt.Sprintf("Error: user IDs %s differ only by case", names), "Rename them so user IDs are unique regardless of case")
+		}
+		warn(fmt.Sprintf("User IDs %s differ only by case, '%s' is used unless the exact case is given", names, keys[0]))
+	}
+}
 
-	// Convert time step to bytes
-	timeBytes := make([]byte, 8)
-	binary.BigEndian.PutUint64(timeBytes, uint64(timeStep))
+// matchUser resolves a lowercased user ID against the case-insensitive config.
+// An exact match wins, then a grouped user's leaf name, otherwise a unique
+// prefix of either is used. When there's no unique match the candidates are
+// returned (possibly none).
+func matchUser(users map[string]string, userID string) (string, []string) {
+	if _, ok := users[userID]; ok {
+		return userID, nil
+	}
 
-	// Create HMAC-SHA1 hash
-	h := hmac.New(sha1.New, key)
-	h.Write(timeBytes)
-	hash := h.Sum(nil)
+	var leaves []string
+	for key := range users {
+		if config.LeafName(key) == userID {
+			leaves = append(leaves, key)
+		}
+	}
+	sort.Strings(leaves)
+	if len(leaves) == 1 {
+		return leaves[0], nil
+	}
+	if len(leaves) > 1 {
+		return "", leaves
+	}
 
-	// Dynamic truncation
-	offset := hash[len(hash)-1] & 0x0F
-	truncatedHash := binary.BigEndian.Uint32(hash[offset:offset+4]) & 0x7FFFFFFF
+	var candidates []string
+	for key := range users {
+		if strings.HasPrefix(key, userID) || strings.HasPrefix(config.LeafName(key), userID) {
+			candidates = append(candidates, key)
+		}
+	}
+	sort.Strings(candidates)
+	if len(candidates) == 1 {
+		return candidates[0], nil
+	}
+	return "", candidates
+}
 
-	// Generate 6-digit code
-	code := truncatedHash % 1000000
+// lookupUser finds the entry for a user ID given on the command line, exiting
+// with a helpful message when it's unknown or an ambiguous prefix
+func lookupUser(cfg config.Config, caseInsensitiveConfig map[string]string, configPath, userArg string) (string, config.Entry) {
+	// The exact spelling picks between user IDs that differ only by case
+	if entry, exists := cfg[userArg]; exists {
+		return userArg, entry
+	}
 
-	return fmt.Sprintf("%06d", code), nil
+	// Case insensitive, and a unique prefix is enough
+	userID, candidates := matchUser(caseInsensitiveConfig, strings.ToLower(userArg))
+	if len(candidates) > 1 {
+		failWith(exitUserNotFound, []string{"user", userArg, "matches", strings.Join(candidates, ",")}, fmt.Sprintf("User '%s' is ambiguous", userArg), fmt.Sprintf("Matching users: %s", strings.Join(candidates, ", ")))
+	}
+	key, exists := caseInsensitiveConfig[userID]
+	if !exists {
+		// Show available users in a stable order, with grouped users under
+		// their full "group/name" keys
+		users := sortedUsers(cfg)
+		var hints []string
+		if suggestion, ok := closestUser(users, userArg); ok {
+			hints = append(hints, fmt.Sprintf("Did you mean '%s'?", suggestion))
+		}
+		if len(users) > 0 {
+			hints = append(hints, fmt.Sprintf("Available users: %s", strings.Join(users, ", ")))
+		}
+		location := "config file at " + configPath
+		context := []string{"user", userArg, "config", configPath}
+		if len(extraConfigPaths) > 0 {
+			location = "config files at " + strings.Join(append([]string{configPath}, extraConfigPaths...), ", ")
+		}
+		failWith(exitUserNotFound, context, fmt.Sprintf("User '%s' not found in %s", userArg, location), hints...)
+	}
+	return key, cfg[key]
 }
 
-// copyToClipboard copies text to the system clipboard
-func copyToClipboard(text string) error {
-	var cmd *exec.Cmd
-
-	switch runtime.GOOS {
-	case "darwin": // macOS
-		cmd = exec.Command("pbcopy")
-	case "linux":
-		// Try xclip first, then xsel
-		if _, err := exec.LookPath("xclip"); err == nil {
-			cmd = exec.Command("xclip", "-selection", "clipboard")
-		} else if _, err := exec.LookPath("xsel"); err == nil {
-			cmd = exec.Command("xsel", "--clipboard", "--input")
-		} else {
-			return fmt.Errorf("no clipboard utility found (install xclip or xsel)")
+// closestUser returns the user ID nearest to a mistyped one by edit
+// distance, compared case-insensitively with both full IDs and the leaf names
+// of grouped users. Only close enough matches count: one edit for short
+// names, up to three for long ones. Ties go to the first in sorted order.
+func closestUser(users []string, userArg string) (string, bool) {
+	target := []rune(strings.ToLower(userArg))
+	maxDistance := min(1+len(target)/4, 3)
+	best, bestDistance := "", maxDistance+1
+	for _, user := range users {
+		for _, name := range []string{user, config.LeafName(user)} {
+			if d := levenshtein(target, []rune(strings.ToLower(name))); d < bestDistance {
+				best, bestDistance = user, d
+			}
 		}
-	case "windows":
-		cmd = exec.Command("cmd", "/c", "clip")
-	default:
-		return fmt.Errorf("clipboard not supported on %s", runtime.GOOS)
 	}
+	return best, best != "" && bestDistance < len(target)
+}
 
-	cmd.Stdin = strings.NewReader(text)
-	return cmd.Run()
+// levenshtein returns the number of single-character insertions, deletions
+// and substitutions turning a into b
+func levenshtein(a, b []rune) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
 }
 
-// createCaseInsensitiveMap creates a map with lowercase keys for case-insensitive lookup
-func createCaseInsensitiveMap(config Config) map[string]string {
-	caseInsensitiveMap := make(map[string]string)
-	for key, value := range config {
-		caseInsensitiveMap[strings.ToLower(key)] = value
+// sortedUsers returns the user keys of the config in alphabetical order
+func sortedUsers(cfg config.Config) []string {
+	users := make([]string, 0, len(cfg))
+	for key := range cfg {
+		users = append(users, key)
 	}
-	return caseInsensitiveMap
+	sort.Strings(users)
+	return users
 }
 
 // printUsage prints the usage information
 func printUsage() {
-	fmt.Fprintf(os.Stderr, "Usage: %s <user_id> [options]\n", filepath.Base(os.Args[0]))
+	fmt.Fprintf(os.Stderr, "Usage: %s <user_id> [user_id...] [options]\n", filepath.Base(os.Args[0]))
+	fmt.Fprintf(os.Stderr, "       %s --list | --check | --all\n", filepath.Base(os.Args[0]))
+	fmt.Fprintf(os.Stderr, "       %s --encrypt | --decrypt\n", filepath.Base(os.Args[0]))
+	fmt.Fprintf(os.Stderr, "\nCommands:\n")
+	fmt.Fprintf(os.Stderr, "  add <user_id> <secret>    Add a user (use --force to replace an existing one)\n")
+	fmt.Fprintf(os.Stderr, "  remove <user_id>          Remove a user\n")
+	fmt.Fprintf(os.Stderr, "  rename <old> <new>        Rename a user, keeping its settings (use --force to replace an existing one)\n")
+	fmt.Fprintf(os.Stderr, "  generate-secret <user_id> Create a random secret for a user and show the URI and QR code to enroll it (--force, --rotate, --length)\n")
+	fmt.Fprintf(os.Stderr, "  import-google <uri>       Import accounts from a Google Authenticator export\n")
+	fmt.Fprintf(os.Stderr, "  qr <user_id>              Show a QR code to enroll the user on another device (--uri-only for the URI)\n")
+	fmt.Fprintf(os.Stderr, "  keychain-add <user_id> <secret>  Store a user's secret in the OS keychain and turn on reading it (--force, --config)\n")
+	fmt.Fprintf(os.Stderr, "  verify <user_id> <code>   Check a code against the user's secret (--window <steps>, default 1)\n")
+	fmt.Fprintf(os.Stderr, "  search <text>             List users whose IDs contain text (--code to show the code of a single match)\n")
+	fmt.Fprintf(os.Stderr, "  info                      Show every user's algorithm, digits and period (no codes or secrets)\n")
+	fmt.Fprintf(os.Stderr, "  export                    Print all accounts as a Google Authenticator migration URI (--uris for otpauth:// URIs, --qr to scan)\n")
+	fmt.Fprintf(os.Stderr, "  prune                     Remove users whose secrets are broken, after confirmation (--dry-run to preview)\n")
+	fmt.Fprintf(os.Stderr, "  selftest                  Check code generation against the RFC 6238 and RFC 4226 test vectors\n")
+	fmt.Fprintf(os.Stderr, "  agent                     Keep an encrypted config's passphrase in memory so later runs don't prompt (--timeout, --stop)\n")
+	fmt.Fprintf(os.Stderr, "  migrate                   Rewrite every entry with its settings spelled out (backs up the file first)\n")
 	fmt.Fprintf(os.Stderr, "\nOptions:\n")
-	fmt.Fprintf(os.Stderr, "  --no-copy    Don't copy to clipboard\n")
-	fmt.Fprintf(os.Stderr, "  --quiet      Only copy to clipboard, don't print to stdout\n")
-	fmt.Fprintf(os.Stderr, "  --help       Show this help message\n")
+	fmt.Fprintf(os.Stderr, "  --version, -v             Print the version, commit and build date\n")
+	fmt.Fprintf(os.Stderr, "  --no-copy                 Don't copy to clipboard\n")
+	fmt.Fprintf(os.Stderr, "  --copy                    Copy even if TOTP_NO_CLIPBOARD(_HOSTS) turns copying off\n")
+	fmt.Fprintf(os.Stderr, "  --quiet, --copy-only      Only copy to clipboard, print nothing on stdout (a failed copy is an error, exit 5)\n")
+	fmt.Fprintf(os.Stderr, "  --cache                   Reuse a code generated earlier in the same time step (for polling scripts)\n")
+	fmt.Fprintf(os.Stderr, "  --audit                   Log who generated which user's code and when to the audit log (never the code)\n")
+	fmt.Fprintf(os.Stderr, "  --group                   Show codes in two groups for reading, like 123 456 (copies stay unspaced)\n")
+	fmt.Fprintf(os.Stderr, "  --raw                     Print only the code, nothing else; it's still copied, silently (add --no-copy to skip)\n")
+	fmt.Fprintf(os.Stderr, "  --no-newline              With --raw or --format, leave out the newline after the last code\n")
+	fmt.Fprintf(os.Stderr, "  --format <template>       Print each code with a Go template like '{{.User}} {{.Code}}', or a preset (code, short, full, tsv)\n")
+	fmt.Fprintf(os.Stderr, "  --osc52                   Copy through the terminal (OSC 52 escape), e.g. over SSH; on by default over SSH without a display\n")
+	fmt.Fprintf(os.Stderr, "  --primary                 Also copy to the primary selection (Linux middle-click paste)\n")
+	fmt.Fprintf(os.Stderr, "  --advance <n>             Skip n HOTP counter values before generating, to resync with the server\n")
+	fmt.Fprintf(os.Stderr, "  --out <path>              Also write the bare code to a file or named pipe (created mode 0600)\n")
+	fmt.Fprintf(os.Stderr, "  --clear-after <seconds>   Clear the clipboard after the given number of seconds\n")
+	fmt.Fprintf(os.Stderr, "  --at <time>               Generate the code for a given time (RFC 3339 or Unix seconds) instead of now\n")
+	fmt.Fprintf(os.Stderr, "  --time-offset <seconds>   Shift the clock used for codes, e.g. -30 for the previous window\n")
+	fmt.Fprintf(os.Stderr, "  --explain                 Show the resolved settings and key length instead of a code\n")
+	fmt.Fprintf(os.Stderr, "  --porcelain               Write errors and warnings as stable \"error: <kind> msg=...\" lines\n")
+	fmt.Fprintf(os.Stderr, "  --strict                  Fail if user IDs in the config differ only by case, the config is readable by others, or a key is too short\n")
+	fmt.Fprintf(os.Stderr, "  --config <path>           Read secrets from <path> instead of the default config file (repeat to merge files)\n")
+	fmt.Fprintf(os.Stderr, "  --stdin                   Read a single secret from stdin instead of the config file\n")
+	fmt.Fprintf(os.Stderr, "  --secret <secret>         Generate a code for <secret> instead of a configured user\n")
+	fmt.Fprintf(os.Stderr, "  --algorithm <name>        HMAC algorithm, overriding the config (SHA1, SHA256, SHA512)\n")
+	fmt.Fprintf(os.Stderr, "  --digits <n>              Code length, overriding the config (6-8)\n")
+	fmt.Fprintf(os.Stderr, "  --period <seconds>        Time step, overriding the config\n")
+	fmt.Fprintf(os.Stderr, "  --json                    Print the code as JSON (errors are also reported as JSON)\n")
+	fmt.Fprintf(os.Stderr, "  --watch                   Keep running and regenerate the code every time step\n")
+	fmt.Fprintf(os.Stderr, "  --adjacent                Also show the codes for the previous and next time steps\n")
+	fmt.Fprintf(os.Stderr, "  --wait                    Wait for a fresh code when the current one is about to expire\n")
+	fmt.Fprintf(os.Stderr, "  --expiry-warning <seconds>  Warn when a code expires within this many seconds (default 5, 0 disables)\n")
+	fmt.Fprintf(os.Stderr, "  --interactive, -i         Choose the user from a menu (default on a terminal with no user_id)\n")
+	fmt.Fprintf(os.Stderr, "  --list                    List all configured users (no codes are generated)\n")
+	fmt.Fprintf(os.Stderr, "  --check                   Validate every secret in the config and report broken entries\n")
+	fmt.Fprintf(os.Stderr, "  --all, --codes            Print a table with every user's current code (HOTP users are skipped)\n")
+	fmt.Fprintf(os.Stderr, "  --encrypt                 Encrypt the config file in place with a passphrase\n")
+	fmt.Fprintf(os.Stderr, "  --decrypt                 Decrypt an encrypted config file back to plaintext\n")
+	fmt.Fprintf(os.Stderr, "  --help                    Show this help message\n")
+	fmt.Fprintf(os.Stderr, "\nEnvironment:\n")
+	fmt.Fprintf(os.Stderr, "  TOTP_PASSPHRASE           Passphrase for an encrypted config file (prompted for otherwise)\n")
+	fmt.Fprintf(os.Stderr, "  TOTP_AGENT_SOCK           Socket the agent listens on (default under $XDG_RUNTIME_DIR)\n")
+	fmt.Fprintf(os.Stderr, "  TOTP_CONFIG               Config file path, used when --config isn't given\n")
+	fmt.Fprintf(os.Stderr, "  TOTP_SECRET_<USER>        Secret for <USER>, overriding the config file (TOTP_SECRET_WORK__GITHUB is work/github)\n")
+	fmt.Fprintf(os.Stderr, "  TOTP_CLIPBOARD_CMD        Command that copies stdin to the clipboard, replacing the built-in detection\n")
+	fmt.Fprintf(os.Stderr, "  TOTP_KEYCHAIN             Set to 1 (or 0) to read (or not) users from the OS keychain, over the config's _keychain\n")
+	fmt.Fprintf(os.Stderr, "  TOTP_CONFIG_CMD           Shell command printing a JSON config to merge in (e.g. from a secret manager)\n")
+	fmt.Fprintf(os.Stderr, "  TOTP_NO_CLIPBOARD         Set to 1 to never copy to the clipboard (unless --copy is given)\n")
+	fmt.Fprintf(os.Stderr, "  TOTP_NO_CLIPBOARD_HOSTS   Comma-separated hostnames (with * wildcards) to never copy on\n")
+	fmt.Fprintf(os.Stderr, "  TOTP_ALGORITHM, TOTP_DIGITS, TOTP_PERIOD  Defaults for users that don't set their own\n")
+	fmt.Fprintf(os.Stderr, "  NO_COLOR                  Set to any value to turn off colored output\n")
+	fmt.Fprintf(os.Stderr, "\nExit codes:\n")
+	fmt.Fprintf(os.Stderr, "  0  Success\n")
+	fmt.Fprintf(os.Stderr, "  1  General error (bad arguments, unreadable config, ...)\n")
+	fmt.Fprintf(os.Stderr, "  2  User not found or ambiguous\n")
+	fmt.Fprintf(os.Stderr, "  3  Config file missing\n")
+	fmt.Fprintf(os.Stderr, "  4  Invalid secret or entry settings\n")
+	fmt.Fprintf(os.Stderr, "  5  Code generated but could not be copied to the clipboard\n")
+	fmt.Fprintf(os.Stderr, "  6  verify: the code doesn't match\n")
 	fmt.Fprintf(os.Stderr, "\nExamples:\n")
 	fmt.Fprintf(os.Stderr, "  %s user_1              # Print code and copy to clipboard\n", filepath.Base(os.Args[0]))
 	fmt.Fprintf(os.Stderr, "  %s user_1 --quiet      # Only copy to clipboard (silent)\n", filepath.Base(os.Args[0]))
 	fmt.Fprintf(os.Stderr, "  %s user_1 --no-copy    # Only print, don't copy\n", filepath.Base(os.Args[0]))
+	fmt.Fprintf(os.Stderr, "  %s git                 # Any unique prefix of a user ID works\n", filepath.Base(os.Args[0]))
+	fmt.Fprintf(os.Stderr, "  %s user_1 user_2       # Print both codes, copy the last one\n", filepath.Base(os.Args[0]))
+	fmt.Fprintf(os.Stderr, "  %s 'work/*'            # Table of every code under work/, nothing copied\n", filepath.Base(os.Args[0]))
+	fmt.Fprintf(os.Stderr, "  %s user_1 --watch      # Live code with countdown (Ctrl-C to exit)\n", filepath.Base(os.Args[0]))
 }
 
 func main() {
-	// Parse arguments
-	if len(os.Args) < 2 {
-		printUsage()
-		os.Exit(1)
+	// Background clipboard clearing started by a previous invocation
+	if len(os.Args) >= 3 && os.Args[1] == clearClipboardCommand {
+		runClipboardClear(os.Args[2], os.Args[3:])
+		return
 	}
 
-	// Check for help flag
-	if os.Args[1] == "--help" || os.Args[1] == "-h" {
-		printUsage()
-		os.Exit(0)
+	// User IDs for the shell completion scripts
+	if len(os.Args) > 1 && os.Args[1] == completeCommand {
+		runComplete(os.Args[2:])
+		return
+	}
+
+	colorOutput = useColor()
+	defaults, err := loadEnvDefaults()
+	if err != nil {
+		fatal(fmt.Sprintf("Error: %v", err))
+	}
+	envDefaults = defaults
+
+	// Subcommands for managing the config file
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			command(os.Args[2:])
+			return
+		}
 	}
 
-	userID := strings.ToLower(os.Args[1]) // Case insensitive
+	var userArgs []string
+	var unknownArg string
+	var configFlags []string
 	var copyToClip = true
+	var forceCopy = false
 	var quietMode = false
+	var rawMode = false
+	var noNewline = false
+	var listMode = false
+	var watchMode = false
+	var clearAfter = 0
+	var advance uint64
+	var encryptMode = false
+	var interactiveMode = false
+	var checkMode = false
+	var allMode = false
+	var decryptMode = false
+	var adjacentMode = false
+	var cacheMode = false
+	var outPath string
+	var formatFlag string
+	var strictMode = false
+	var explainMode = false
+	var waitMode = false
+	var expiryWarning = defaultExpiryWarning
+	var stdinMode = false
+	var secretFlag string
+	var overrides config.Entry
 
-	// Parse flags
-	for i := 2; i < len(os.Args); i++ {
-		switch os.Args[i] {
+	// Parse flags and user IDs, which may appear in any order
+	args := os.Args[1:]
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch arg {
+		case "--help", "-h":
+			printUsage()
+			os.Exit(0)
+		case "--version", "-v":
+			fmt.Println(versionString())
+			os.Exit(0)
 		case "--no-copy":
 			copyToClip = false
-		case "--quiet":
+		case "--copy":
+			forceCopy = true
+		case "--quiet", "--copy-only":
 			quietMode = true
+		case "--raw":
+			rawMode = true
+		case "--no-newline":
+			noNewline = true
+		case "--primary":
+			copyPrimary = true
+		case "--osc52":
+			osc52Copy = true
+		case "--group":
+			groupDigits = true
+		case "--cache":
+			cacheMode = true
+		case "--audit":
+			auditFlag = true
+		case "--list":
+			listMode = true
+		case "--check":
+			checkMode = true
+		case "--all", "--codes":
+			allMode = true
+		case "--adjacent":
+			adjacentMode = true
+		case "--strict":
+			strictMode = true
+			strictPermissions = true
+			strictKeyLength = true
+		case "--explain":
+			explainMode = true
+		case "--wait":
+			waitMode = true
+		case "--expiry-warning":
+			if i+1 >= len(args) {
+				fatal("--expiry-warning requires a number of seconds")
+			}
+			i++
+			seconds, err := strconv.Atoi(args[i])
+			if err != nil || seconds < 0 {
+				fatal(fmt.Sprintf("Invalid --expiry-warning value '%s': must be zero or a positive number of seconds", args[i]))
+			}
+			expiryWarning = seconds
+		case "--stdin":
+			stdinMode = true
+		case "--watch":
+			watchMode = true
+		case "--json":
+			jsonOutput = true
+		case "--porcelain":
+			porcelainOutput = true
+		case "--interactive", "-i":
+			interactiveMode = true
+		case "--encrypt":
+			encryptMode = true
+		case "--decrypt":
+			decryptMode = true
+		case "--config":
+			if i+1 >= len(args) {
+				fatal("--config requires a file path")
+			}
+			i++
+			configFlags = append(configFlags, args[i])
+		case "--completion":
+			if i+1 >= len(args) {
+				fatal("--completion requires a shell (bash or zsh)")
+			}
+			if err := printCompletion(args[i+1]); err != nil {
+				fatal(fmt.Sprintf("Error: %v", err))
+			}
+			os.Exit(0)
+		case "--secret":
+			if i+1 >= len(args) {
+				fatal("--secret requires a base32 secret")
+			}
+			i++
+			secretFlag = args[i]
+		case "--algorithm":
+			if i+1 >= len(args) {
+				fatal("--algorithm requires a name (SHA1, SHA256 or SHA512)")
+			}
+			i++
+			overrides.Algorithm = args[i]
+		case "--digits", "--period":
+			if i+1 >= len(args) {
+				fatal(fmt.Sprintf("%s requires a number", arg))
+			}
+			i++
+			n, err := strconv.Atoi(args[i])
+			if err != nil || n <= 0 {
+				fatal(fmt.Sprintf("Invalid %s value '%s': must be a positive number", arg, args[i]))
+			}
+			if arg == "--digits" {
+				overrides.Digits = n
+			} else {
+				overrides.Period = n
+			}
+		case "--time-offset":
+			if i+1 >= len(args) {
+				fatal("--time-offset requires a number of seconds")
+			}
+			i++
+			offset, err := strconv.ParseInt(args[i], 10, 64)
+			if err != nil {
+				fatal(fmt.Sprintf("Invalid --time-offset value '%s': must be a whole number of seconds", args[i]))
+			}
+			timeOffset = offset
+		case "--at":
+			if i+1 >= len(args) {
+				fatal("--at requires a time")
+			}
+			i++
+			t, err := parseAtTime(args[i])
+			if err != nil {
+				fatal(err.Error())
+			}
+			atTime = &t
+		case "--advance":
+			if i+1 >= len(args) {
+				fatal("--advance requires a number of counter values")
+			}
+			i++
+			n, err := strconv.ParseUint(args[i], 10, 64)
+			if err != nil {
+				fatal(fmt.Sprintf("Invalid --advance value '%s': must be zero or a positive number", args[i]))
+			}
+			advance = n
+		case "--clear-after":
+			if i+1 >= len(args) {
+				fatal("--clear-after requires a number of seconds")
+			}
+			i++
+			seconds, err := strconv.Atoi(args[i])
+			if err != nil || seconds <= 0 {
+				fatal(fmt.Sprintf("Invalid --clear-after value '%s': must be a positive number of seconds", args[i]))
+			}
+			clearAfter = seconds
+		case "--out":
+			if i+1 >= len(args) {
+				fatal("--out requires a file path")
+			}
+			i++
+			outPath = args[i]
+		case "--format":
+			if i+1 >= len(args) {
+				fatal(fmt.Sprintf("--format requires a template or a preset (%s)", formatPresetNames()))
+			}
+			i++
+			formatFlag = args[i]
 		default:
-			fmt.Fprintf(os.Stderr, "⚠️ Unknown option: %s\n", os.Args[i])
+			if strings.HasPrefix(arg, "-") {
+				if unknownArg == "" {
+					unknownArg = arg
+				}
+				continue
+			}
+			userArgs = append(userArgs, arg)
+		}
+	}
+
+	if unknownArg != "" {
+		if jsonOutput || porcelainOutput {
+			fatal(fmt.Sprintf("Unknown option: %s", unknownArg))
+		}
+		fmt.Fprintf(os.Stderr, "⚠️ Unknown option: %s\n", unknownArg)
+		printUsage()
+		os.Exit(1)
+	}
+	adHoc := stdinMode || secretFlag != ""
+	if stdinMode && secretFlag != "" {
+		fatal("--stdin can't be combined with --secret")
+	}
+	if adHoc && len(userArgs) > 0 {
+		fatal("--stdin and --secret can't be combined with user IDs")
+	}
+	if (overrides.Algorithm != "" || overrides.Digits != 0 || overrides.Period != 0) && (listMode || checkMode || allMode || encryptMode || decryptMode) {
+		fatal("--algorithm, --digits and --period only apply to user IDs, --secret and --stdin")
+	}
+	if allMode && (adHoc || len(userArgs) > 0) {
+		fatal("--all prints every user's code and can't be combined with user IDs, --secret or --stdin")
+	}
+	// --raw is for capturing a code, so it makes no sense where none is printed
+	if rawMode && (listMode || checkMode || allMode || explainMode || encryptMode || decryptMode) {
+		fatal("--raw only applies when generating codes, not to --list, --check, --all, --explain, --encrypt or --decrypt")
+	}
+	if formatFlag != "" && (listMode || checkMode || allMode || explainMode || encryptMode || decryptMode) {
+		fatal("--format only applies when generating codes, not to --list, --check, --all, --explain, --encrypt or --decrypt")
+	}
+
+	// Locate the config, which a secret given directly doesn't need
+	var configPath string
+	var explicit bool
+	if !adHoc {
+		var configFlag string
+		if len(configFlags) > 0 {
+			configFlag, extraConfigPaths = configFlags[0], configFlags[1:]
+		}
+		var err error
+		if configPath, explicit, err = resolveConfigPath(configFlag); err != nil {
+			fatal(fmt.Sprintf("Error: %v", err))
+		}
+		if len(extraConfigPaths) > 0 {
+			if encryptMode || decryptMode {
+				fatal("--encrypt and --decrypt only take a single --config")
+			}
+			for _, path := range configFlags {
+				if _, err := os.Stat(path); err != nil {
+					failWith(exitConfigMissing, []string{"config", path}, fmt.Sprintf("Error: config file not found: %s", path))
+				}
+			}
+		}
+	}
+
+	if len(userArgs) == 0 && !adHoc && !listMode && !checkMode && !allMode && !encryptMode && !decryptMode && !interactiveMode {
+		if user := defaultUser(readSettings(configPath)); user != "" {
+			userArgs = append(userArgs, user)
+		}
+	}
+	if len(userArgs) == 0 && !adHoc && !listMode && !checkMode && !allMode && !encryptMode && !decryptMode {
+		// Offer a menu on a terminal, otherwise keep the usage-and-exit behavior
+		if !interactiveMode && isTerminal(os.Stdin) && isTerminal(os.Stdout) {
+			interactiveMode = true
+		}
+		if !interactiveMode {
+			if jsonOutput {
+				fatal("Missing user ID")
+			}
 			printUsage()
 			os.Exit(1)
 		}
 	}
+	if interactiveMode && !isTerminal(os.Stdin) {
+		fatal("--interactive requires a terminal")
+	}
+	if forceCopy && !copyToClip {
+		fatal("--copy can't be combined with --no-copy")
+	}
+	// --quiet only copies, so without a copy (or --out) it would silently do
+	// nothing at all, which scripts couldn't tell from success
+	generating := !listMode && !checkMode && !allMode && !explainMode && !encryptMode && !decryptMode
+	if quietMode && !copyToClip && outPath == "" && generating {
+		fatal("--quiet (--copy-only) only copies the code, so with --no-copy nothing would happen", "Add --out <path> to write the code somewhere, or drop one of the two")
+	}
+	if copyToClip && configPath != "" {
+		loadClipboardSettings(readSettings(configPath))
+	}
+	if variable, disabled := clipboardDisabled(); disabled && !forceCopy {
+		copyToClip = false
+		if quietMode && outPath == "" && generating {
+			failWith(exitClipboard, []string{"setting", variable}, fmt.Sprintf("Error: clipboard copying is turned off by %s, so --quiet (--copy-only) has nothing to do", variable), "Use --copy to copy anyway")
+		} else if quietMode {
+			warn(fmt.Sprintf("Clipboard copying is turned off by %s, use --copy to copy anyway", variable))
+		}
+	}
+	if copyPrimary && runtime.GOOS != "linux" && !osc52Copy {
+		warn(fmt.Sprintf("--primary only applies on Linux, ignoring it on %s", runtime.GOOS))
+		copyPrimary = false
+	}
+	if watchMode && quietMode {
+		fatal("--watch can't be combined with --quiet")
+	}
+	if rawMode && (watchMode || jsonOutput || quietMode || adjacentMode) {
+		fatal("--raw can't be combined with --watch, --json, --quiet or --adjacent")
+	}
+	if noNewline && !rawMode && formatFlag == "" {
+		fatal("--no-newline only applies with --raw or --format")
+	}
+	if formatFlag != "" && (watchMode || jsonOutput || quietMode || rawMode) {
+		fatal("--format can't be combined with --watch, --json, --quiet or --raw")
+	}
+	// Check the template now, so a mistake in it doesn't cost an HOTP counter
+	var format *template.Template
+	if formatFlag != "" {
+		var err error
+		if format, err = parseFormat(formatFlag); err != nil {
+			fatal(fmt.Sprintf("Invalid --format template: %v", err), fmt.Sprintf("Presets: %s", formatPresetNames()))
+		}
+	}
+	if watchMode && len(userArgs) > 1 {
+		fatal("--watch only supports a single user")
+	}
+	if watchMode && clearAfter > 0 {
+		fatal("--clear-after can't be combined with --watch")
+	}
+	if outPath != "" && watchMode {
+		fatal("--out can't be combined with --watch")
+	}
+	if atTime != nil && (watchMode || waitMode) {
+		fatal("--at can't be combined with --watch or --wait")
+	}
+	if watchMode && adjacentMode {
+		fatal("--adjacent can't be combined with --watch")
+	}
+
+	opts := codeOptions{
+		copyToClip: copyToClip,
+		quiet:      quietMode,
+		raw:        rawMode,
+		watch:      watchMode,
+		adjacent:   adjacentMode,
+		clearAfter: clearAfter,
+		wait:       waitMode,
+		warnUnder:  expiryWarning,
+		overrides:  overrides,
+		advance:    advance,
+		cache:      cacheMode,
+		out:        outPath,
+		format:     format,
+		noNewline:  noNewline,
+	}
+
+	// A secret given directly doesn't need a config file at all
+	if adHoc {
+		label, secret := "secret", secretFlag
+		if stdinMode {
+			var err error
+			label = "stdin"
+			if secret, err = readStdinSecret(); err != nil {
+				fatal(fmt.Sprintf("Error: %v", err))
+			}
+		}
+		entry, err := parseSecret(secret, overrides)
+		if err != nil {
+			failWith(exitInvalidSecret, []string{"source", label}, fmt.Sprintf("Error: invalid secret: %v", err))
+		}
+		if explainMode {
+			explainEntries([]string{label}, []config.Entry{entry})
+			return
+		}
+		showCodes([]string{label}, []config.Entry{entry}, "", opts)
+		return
+	}
 
 	// Load configuration
-	config, err := loadConfig()
+
+	// Convert the config file between plaintext and encrypted form
+	if encryptMode || decryptMode {
+		if _, err := os.Stat(configPath); err != nil {
+			failWith(exitConfigMissing, []string{"config", configPath}, fmt.Sprintf("Error: config file not found: %s", configPath))
+		}
+		if encryptMode {
+			if err := encryptConfigFile(configPath); err != nil {
+				fatal(fmt.Sprintf("Error: %v", err))
+			}
+			fmt.Printf("🔒 Encrypted %s\n", configPath)
+		} else {
+			if err := decryptConfigFile(configPath); err != nil {
+				fatal(fmt.Sprintf("Error: %v", err))
+			}
+			fmt.Printf("🔓 Decrypted %s\n", configPath)
+		}
+		return
+	}
+
+	// Check every entry individually, so one broken secret doesn't hide the rest
+	if checkMode {
+		results, err := checkConfig(configPath)
+		if err != nil {
+			failWith(configExitCode(err), []string{"config", configPath}, fmt.Sprintf("Error: %v", err))
+		}
+		failed := 0
+		for _, result := range results {
+			if !result.Valid {
+				failed++
+			}
+		}
+		if jsonOutput {
+			printJSON(os.Stdout, results)
+		} else {
+			for _, result := range results {
+				if result.Valid && result.Warning != "" {
+					fmt.Printf("⚠️ %s: %s\n", result.User, result.Warning)
+				} else if result.Valid {
+					fmt.Printf("✅ %s\n", result.User)
+				} else {
+					fmt.Printf("❌ %s: %s\n", result.User, result.Error)
+				}
+			}
+			fmt.Printf("\n%d valid, %d broken\n", len(results)-failed, failed)
+		}
+		if failed > 0 {
+			os.Exit(exitInvalidSecret)
+		}
+		return
+	}
+
+	// Print a table of every user's current code
+	if allMode {
+		printAllCodes(configPath)
+		return
+	}
+
+	cfg, err := loadConfig(configPath, explicit)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "⚠️ Error: %v\n", err)
-		os.Exit(1)
+		failWith(configExitCode(err), []string{"config", configPath}, fmt.Sprintf("Error: %v", err))
+	}
+
+	// List users without generating any codes
+	if listMode {
+		users := sortedUsers(cfg)
+		if jsonOutput {
+			printJSON(os.Stdout, users)
+			return
+		}
+		for _, user := range users {
+			fmt.Println(user)
+		}
+		return
+	}
+
+	// Pick the user from a menu when none was given
+	if interactiveMode && len(userArgs) == 0 {
+		user, err := selectUser(sortedUsers(cfg))
+		if err != nil {
+			fatal(fmt.Sprintf("Error: %v", err))
+		}
+		userArgs = append(userArgs, user)
 	}
 
 	// Create case-insensitive lookup
-	caseInsensitiveConfig := createCaseInsensitiveMap(config)
+	caseInsensitiveConfig, collisions := createCaseInsensitiveMap(cfg)
+	reportCaseCollisions(collisions, strictMode)
 
-	// Find the secret for the user
-	secret, exists := caseInsensitiveConfig[userID]
-	if !exists {
-		homeDir, err := os.UserHomeDir()
+	// Find the entry for every requested user before generating anything.
+	// Patterns like 'work/*' stand for every user they match.
+	var userIDs []string
+	var matched []bool
+	patternUsed := false
+	for _, userArg := range userArgs {
+		if isUserPattern(userArg) {
+			for _, user := range lookupPattern(cfg, userArg) {
+				userIDs = append(userIDs, user)
+				matched = append(matched, true)
+			}
+			patternUsed = true
+			continue
+		}
+		user, _ := lookupUser(cfg, caseInsensitiveConfig, configPath, userArg)
+		userIDs = append(userIDs, user)
+		matched = append(matched, false)
+	}
+	entries := make([]config.Entry, len(userIDs))
+	for i, user := range userIDs {
+		entry, err := applyOverrides(cfg[user], overrides)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "⚠️ Error: %v\n", err)
-			os.Exit(1)
+			failWith(exitInvalidSecret, []string{"user", user}, fmt.Sprintf("Error: invalid settings for user '%s': %v", user, err))
 		}
-		configPath := filepath.Join(homeDir, ".totp_config.json")
-		fmt.Fprintf(os.Stderr, "⚠️ User '%s' not found in config file at %s\n", os.Args[1], configPath)
+		entries[i] = entry
+	}
+
+	if explainMode {
+		explainEntries(userIDs, entries)
+		return
+	}
 
-		// Show available users
-		var users []string
-		for key := range config {
-			users = append(users, key)
+	// When a pattern brings in several users their codes are shown in a
+	// table, and none is copied since there's no telling which one is wanted
+	if patternUsed && len(userIDs) > 1 {
+		if opts.watch {
+			fatal(fmt.Sprintf("--watch only supports a single user, and %d users matched", len(userIDs)))
 		}
-		if len(users) > 0 {
-			fmt.Fprintf(os.Stderr, "⚠️ Available users: %s\n", strings.Join(users, ", "))
+		if opts.quiet {
+			fatal("--quiet would print nothing: codes aren't copied when a pattern matches several users")
 		}
-		os.Exit(1)
+		if userIDs, entries = skipPatternHOTP(userIDs, entries, matched); len(userIDs) == 0 {
+			fail(exitUserNotFound, "Only HOTP users matched, name one to generate its code")
+		}
+		opts.copyToClip = false
+		opts.table = !opts.adjacent
 	}
+	showCodes(userIDs, entries, configPath, opts)
+}
 
-	// Generate TOTP code
-	code, err := generateTOTP(secret)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "⚠️ Error generating TOTP: %v\n", err)
-		fmt.Fprintf(os.Stderr, "⚠️ Make sure the secret is a valid base32 string\n")
-		os.Exit(1)
+// codeOptions are the flags that control how codes are shown
+type codeOptions struct {
+	copyToClip bool
+	quiet      bool
+	raw        bool
+	watch      bool
+	adjacent   bool
+	clearAfter int
+	wait       bool
+	warnUnder  int
+	overrides  config.Entry
+	advance    uint64
+	cache      bool
+	out        string
+	format     *template.Template
+	noNewline  bool
+	table      bool
+}
+
+// expandRotations replaces each entry in the middle of a secret rotation
+// with one per secret, oldest first, so every valid code is shown and the
+// newest one is copied
+func expandRotations(userIDs []string, entries []config.Entry) ([]string, []config.Entry) {
+	var expandedIDs []string
+	var expanded []config.Entry
+	for i, entry := range entries {
+		for _, rotated := range entry.Rotated() {
+			expandedIDs = append(expandedIDs, userIDs[i])
+			expanded = append(expanded, rotated)
+		}
+	}
+	return expandedIDs, expanded
+}
+
+// showCodes generates, copies and prints the codes for the given users.
+// configPath is where HOTP counters are saved.
+func showCodes(userIDs []string, entries []config.Entry, configPath string, opts codeOptions) {
+	if opts.copyToClip && sshWithoutDisplay() && !usingOSC52() {
+		warn(fmt.Sprintf("Running over SSH without DISPLAY or WAYLAND_DISPLAY, and with no terminal for OSC 52, so the code goes to this machine's clipboard, not yours; use --no-copy (or set %s=1)", noClipboardEnvVar))
+	}
+	// Keep regenerating the code until interrupted
+	if opts.watch {
+		if entries[0].IsHOTP() {
+			fatal("--watch isn't supported for HOTP (counter-based) users")
+		}
+		if err := auditCodes(configPath, userIDs, entries); err != nil {
+			fatal(fmt.Sprintf("Error: %v", err))
+		}
+		if err := watchCode(userIDs[0], entries[0], opts.copyToClip); err != nil {
+			failWith(exitInvalidSecret, []string{"user", userIDs[0]}, fmt.Sprintf("Error generating TOTP: %v", err))
+		}
+		return
+	}
+
+	if opts.advance > 0 {
+		for i, entry := range entries {
+			if !entry.IsHOTP() {
+				fatal(fmt.Sprintf("--advance only applies to HOTP users, '%s' is time-based", userIDs[i]))
+			}
+		}
+	}
+
+	userIDs, entries = expandRotations(userIDs, entries)
+	checkKeyLengths(userIDs, entries)
+
+	// With --wait, hold off until codes about to expire have been replaced
+	// by fresh ones. Otherwise they're shown with a warning below.
+	if opts.wait {
+		var wait time.Duration
+		for _, entry := range entries {
+			if !entry.IsHOTP() && secondsRemaining(entry.Options().Period) <= opts.warnUnder {
+				wait = max(wait, untilNextStep(entry.Options().Period))
+			}
+		}
+		if wait > 0 {
+			seconds := int(wait.Round(time.Second) / time.Second)
+			if porcelainOutput {
+				fmt.Fprintf(os.Stderr, "info: waiting seconds=%d\n", seconds)
+			} else if !opts.quiet && !jsonOutput {
+				fmt.Fprintf(os.Stderr, "⏳ Waiting %ds for a fresh code...\n", seconds)
+			}
+			time.Sleep(wait)
+		}
+	}
+
+	// Record who asked for which codes before any is generated, so a code
+	// can't be shown without its log line
+	if err := auditCodes(configPath, userIDs, entries); err != nil {
+		fatal(fmt.Sprintf("Error: %v", err))
+	}
+
+	// Generate codes. HOTP users consume a counter value, which is saved
+	// back to the config file.
+	codes := make([]string, len(entries))
+	counters := make([]*uint64, len(entries))
+	previous := make([]string, len(entries))
+	next := make([]string, len(entries))
+	for i, entry := range entries {
+		if entry.IsHOTP() {
+			code, counter, err := generateHOTP(configPath, userIDs[i], opts.overrides, opts.advance)
+			if err != nil {
+				failWith(exitInvalidSecret, []string{"user", userIDs[i]}, fmt.Sprintf("Error generating HOTP for '%s': %v", userIDs[i], err))
+			}
+			codes[i] = code
+			counters[i] = &counter
+			continue
+		}
+
+		var code string
+		var err error
+		if opts.cache {
+			code, err = cachedCode(userIDs[i], entry)
+		} else {
+			code, err = entryCode(entry, 0)
+		}
+		if err != nil {
+			failWith(exitInvalidSecret, []string{"user", userIDs[i]}, fmt.Sprintf("Error generating TOTP for '%s': %v", userIDs[i], err), "Make sure the secret is a valid base32 string")
+		}
+		codes[i] = code
+
+		// The codes either side of the current one, for when the clocks of
+		// this machine and the service disagree near a boundary
+		if opts.adjacent {
+			previous[i], _ = entryCode(entry, -1)
+			next[i], _ = entryCode(entry, 1)
+		}
+	}
+
+	// Hand the bare codes to whatever reads --out before anything else
+	if opts.out != "" {
+		if err := writeCodes(opts.out, codes); err != nil {
+			fatal(fmt.Sprintf("Error: could not write codes to %s: %v", opts.out, err))
+		}
 	}
 
-	// Copy to clipboard (unless disabled)
-	if copyToClip {
-		if err := copyToClipboard(code); err != nil {
-			// Don't fail the program if clipboard copy fails, just warn
-			if !quietMode {
-				fmt.Fprintf(os.Stderr, "⚠️ Warning: Could not copy to clipboard: %v\n", err)
+	// Copy to clipboard (unless disabled). With several users only the last
+	// code is copied, so it matches the last block printed. What was there
+	// before is kept to put back when clearing.
+	last := len(codes) - 1
+	copied := false
+	clear := clipboardClear{Code: codes[last]}
+	if opts.copyToClip && opts.clearAfter > 0 && !usingOSC52() {
+		clear.Previous, clear.Restore = readClipboard()
+	}
+	if opts.copyToClip {
+		if err := copyToClipboard(codes[last]); err != nil {
+			// Don't fail the program if clipboard copy fails, just warn. With
+			// --quiet the copy is all there is, so the failure is the one
+			// thing still reported.
+			if opts.quiet {
+				failWith(exitClipboard, []string{"user", userIDs[last]}, fmt.Sprintf("Error: could not copy to clipboard: %v", err))
 			}
+			warn(fmt.Sprintf("Could not copy to clipboard: %v", err))
+		} else {
+			copied = true
 		}
 	}
 
-	// Output the code (unless in quiet mode)
-	if !quietMode {
-		fmt.Println("👤 User		: ", userID)
-		fmt.Println("🔑 TOTP Code	: ", code)
-		fmt.Println("📋 Copied to clipboard")
+	// Clear the clipboard later, but only if there is something to clear
+	clearScheduled := false
+	if copied && opts.clearAfter > 0 {
+		if err := scheduleClipboardClear(opts.clearAfter, clear); err != nil {
+			if !opts.quiet {
+				warn(fmt.Sprintf("Could not schedule clipboard clearing: %v", err))
+			}
+		} else {
+			clearScheduled = true
+		}
 	}
 
+	// Output the codes (unless in quiet mode)
+	if opts.quiet {
+		if opts.copyToClip && !copied {
+			os.Exit(exitClipboard)
+		}
+		return
+	}
+	// With --raw only the codes are printed, one per line, so they can be
+	// captured with $(...). Copying still happens, silently.
+	if opts.raw {
+		fmt.Print(strings.Join(codes, "\n"))
+		if !opts.noNewline {
+			fmt.Println()
+		}
+		if opts.copyToClip && !copied {
+			os.Exit(exitClipboard)
+		}
+		return
+	}
+	// With --format the template decides everything that's printed
+	if opts.format != nil {
+		data := make([]formatData, len(codes))
+		for i, code := range codes {
+			data[i] = formatData{User: userIDs[i], Name: entries[i].DisplayName(userIDs[i]), Code: code, Previous: previous[i], Next: next[i], Rotation: entries[i].Rotation}
+			if counters[i] != nil {
+				data[i].HOTP = true
+				data[i].Counter = *counters[i]
+			} else {
+				period := entries[i].Options().Period
+				data[i].Period = period
+				data[i].ExpiresIn = secondsRemaining(period)
+				data[i].Elapsed = period - data[i].ExpiresIn
+				data[i].Expires = time.Unix(now()+int64(data[i].ExpiresIn), 0)
+			}
+		}
+		if err := printFormatted(opts.format, data, opts.noNewline); err != nil {
+			fatal(fmt.Sprintf("Error: %v", err))
+		}
+		if opts.copyToClip && !copied {
+			os.Exit(exitClipboard)
+		}
+		return
+	}
+	if opts.table && !jsonOutput {
+		printCodeTable(userIDs, entries, codes)
+	} else {
+		for i, code := range codes {
+			if jsonOutput {
+				out := codeOutput{User: userIDs[i], Code: code, Counter: counters[i], Previous: previous[i], Next: next[i], Rotation: entries[i].Rotation}
+				if counters[i] == nil {
+					period := entries[i].Options().Period
+					remaining := secondsRemaining(period)
+					elapsed := period - remaining
+					out.ExpiresIn, out.Elapsed, out.Period = remaining, &elapsed, period
+				}
+				printJSON(os.Stdout, out)
+				continue
+			}
+			if i > 0 {
+				fmt.Println()
+			}
+			fmt.Println(formatField("👤", "User", colorize(ansiCyan, entries[i].DisplayName(userIDs[i]))))
+			if entries[i].Rotation != "" {
+				fmt.Println(formatField("🔄", "Secret", entries[i].Rotation))
+			}
+			if counters[i] != nil {
+				fmt.Println(formatField("🔑", "HOTP Code", colorize(ansiGreen, displayCode(code))))
+				fmt.Println(formatField("🔢", "Counter", strconv.FormatUint(*counters[i], 10)))
+			} else {
+				if previous[i] != "" {
+					fmt.Println(formatField("⏮️", "Previous", displayCode(previous[i])))
+				}
+				if atTime != nil {
+					fmt.Println(formatField("🕰️", "At", time.Unix(now(), 0).UTC().Format(time.RFC3339)))
+				}
+				fmt.Println(formatField("🔑", "TOTP Code", colorize(ansiGreen, displayCode(code))))
+				if next[i] != "" {
+					fmt.Println(formatField("⏭️", "Next", displayCode(next[i])))
+				}
+				// Both ways of looking at the window: how long the code has left,
+				// and how far into its period it is
+				period := entries[i].Options().Period
+				remaining := secondsRemaining(period)
+				window := fmt.Sprintf("%s (%ds of %ds elapsed)", colorize(expiryColor(remaining), fmt.Sprintf("%ds", remaining)), period-remaining, period)
+				fmt.Println(formatField("⏳", "Expires in", window))
+			}
+		}
+	}
+	for i, entry := range entries {
+		if i > 0 && userIDs[i] == userIDs[i-1] {
+			// The other secrets of a rotation expire at the same time
+			continue
+		}
+		if remaining := secondsRemaining(entry.Options().Period); counters[i] == nil && atTime == nil && remaining <= opts.warnUnder {
+			warn(fmt.Sprintf("code for '%s' expires in %ds, consider waiting (or use --wait)", userIDs[i], remaining))
+		}
+	}
+	// Only confirm the copy when it actually happened
+	if copied && !jsonOutput {
+		fmt.Println(copiedMessage())
+		if clearScheduled {
+			if clear.Restore {
+				fmt.Printf("🧹 Clipboard will be restored in %ds\n", opts.clearAfter)
+			} else {
+				fmt.Printf("🧹 Clipboard will be cleared in %ds\n", opts.clearAfter)
+			}
+		}
+	}
+	if opts.copyToClip && !copied {
+		os.Exit(exitClipboard)
+	}
 }
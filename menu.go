@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// menuHeight is the maximum number of users shown at once in the menu
+const menuHeight = 10
+
+// selectUser shows an interactive menu of users on stderr and returns the
+// chosen one. Arrow keys move the selection and typing filters the list.
+// Windows consoles get a numbered prompt instead, since raw mode isn't
+// available there without extra dependencies.
+func selectUser(users []string) (string, error) {
+	if len(users) == 0 {
+		return "", fmt.Errorf("no users configured")
+	}
+	if runtime.GOOS == "windows" {
+		return selectUserNumbered(users)
+	}
+
+	restore, err := enableRawMode()
+	if err != nil {
+		return selectUserNumbered(users)
+	}
+	defer restore()
+
+	reader := bufio.NewReader(os.Stdin)
+	filter := ""
+	selected := 0
+	drawn := 0
+	for {
+		matches := filterUsers(users, filter)
+		if selected >= len(matches) {
+			selected = len(matches) - 1
+		}
+		if selected < 0 {
+			selected = 0
+		}
+		drawn = drawMenu(matches, filter, selected, drawn)
+
+		b, err := reader.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		switch b {
+		case '\r', '\n':
+			if len(matches) == 0 {
+				continue
+			}
+			clearMenu(drawn)
+			return matches[selected], nil
+		case 3, 4: // Ctrl-C, Ctrl-D
+			clearMenu(drawn)
+			return "", fmt.Errorf("selection cancelled")
+		case 127, 8: // Backspace
+			if len(filter) > 0 {
+				filter = filter[:len(filter)-1]
+				selected = 0
+			}
+		case 27: // Escape, possibly the start of an arrow key sequence
+			if reader.Buffered() == 0 {
+				clearMenu(drawn)
+				return "", fmt.Errorf("selection cancelled")
+			}
+			next, _ := reader.ReadByte()
+			if next != '[' {
+				continue
+			}
+			key, _ := reader.ReadByte()
+			switch key {
+			case 'A':
+				if selected > 0 {
+					selected--
+				}
+			case 'B':
+				if selected < len(matches)-1 {
+					selected++
+				}
+			}
+		default:
+			if b >= 32 && b < 127 {
+				filter += string(b)
+				selected = 0
+			}
+		}
+	}
+}
+
+// filterUsers returns the users containing filter, ignoring case
+func filterUsers(users []string, filter string) []string {
+	if filter == "" {
+		return users
+	}
+	filter = strings.ToLower(filter)
+	var matches []string
+	for _, user := range users {
+		if strings.Contains(strings.ToLower(user), filter) {
+			matches = append(matches, user)
+		}
+	}
+	return matches
+}
+
+// drawMenu renders the menu on stderr, replacing the previously drawn lines,
+// and returns how many lines were drawn below the prompt
+func drawMenu(matches []string, filter string, selected, previous int) int {
+	var b strings.Builder
+
+	// Move back up to the prompt line and clear everything below it
+	if previous > 0 {
+		fmt.Fprintf(&b, "\033[%dA", previous)
+	}
+	b.WriteString("\r\033[J")
+
+	// Scroll the visible window so the selection is always on screen
+	start := 0
+	if selected >= menuHeight {
+		start = selected - menuHeight + 1
+	}
+	end := min(start+menuHeight, len(matches))
+
+	lines := 0
+	for i := start; i < end; i++ {
+		if i == selected {
+			fmt.Fprintf(&b, "\033[7m> %s\033[0m\r\n", matches[i])
+		} else {
+			fmt.Fprintf(&b, "  %s\r\n", matches[i])
+		}
+		lines++
+	}
+	if len(matches) == 0 {
+		b.WriteString("  (no matching users)\r\n")
+		lines++
+	}
+	fmt.Fprintf(&b, "🔍 Select user: %s", filter)
+
+	fmt.Fprint(os.Stderr, b.String())
+	return lines
+}
+
+// clearMenu erases a menu drawn by drawMenu
+func clearMenu(lines int) {
+	if lines > 0 {
+		fmt.Fprintf(os.Stderr, "\033[%dA", lines)
+	}
+	fmt.Fprint(os.Stderr, "\r\033[J")
+}
+
+// enableRawMode switches the terminal to raw mode with stty and returns a
+// function that restores the previous settings
+func enableRawMode() (func(), error) {
+	save := exec.Command("stty", "-g")
+	save.Stdin = os.Stdin
+	state, err := save.Output()
+	if err != nil {
+		return nil, err
+	}
+
+	raw := exec.Command("stty", "raw", "-echo")
+	raw.Stdin = os.Stdin
+	if err := raw.Run(); err != nil {
+		return nil, err
+	}
+
+	return func() {
+		restore := exec.Command("stty", strings.TrimSpace(string(state)))
+		restore.Stdin = os.Stdin
+		restore.Run()
+	}, nil
+}
+
+// selectUserNumbered lists the users with numbers and reads a choice, which
+// may be either a number or a user ID
+func selectUserNumbered(users []string) (string, error) {
+	for i, user := range users {
+		fmt.Fprintf(os.Stderr, "  %2d) %s\n", i+1, user)
+	}
+	fmt.Fprintf(os.Stderr, "🔍 Select user [1-%d]: ", len(users))
+
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", fmt.Errorf("selection cancelled")
+	}
+	choice := strings.TrimSpace(line)
+	if n, err := strconv.Atoi(choice); err == nil {
+		if n < 1 || n > len(users) {
+			return "", fmt.Errorf("invalid selection %d", n)
+		}
+		return users[n-1], nil
+	}
+	if choice == "" {
+		return "", fmt.Errorf("selection cancelled")
+	}
+	return choice, nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+
+	"github.com/nsvirk/totp-cli/config"
+)
+
+// errAlreadyMigrated stops updateRawConfig from rewriting a config file that
+// has nothing left to migrate
+var errAlreadyMigrated = errors.New("already migrated")
+
+// runMigrate rewrites every entry of the config file in the object form,
+// with the default settings spelled out, so accounts can be annotated
+// individually. The original file is backed up first. Running it again on
+// a migrated file changes nothing.
+func runMigrate(args []string) {
+	var configFlag string
+	positional, err := parseCommandArgs(args, nil, map[string]*string{"--config": &configFlag})
+	if err != nil {
+		fatal(fmt.Sprintf("Error: %v", err))
+	}
+	if len(positional) != 0 {
+		fatal("Usage: migrate [--config <path>]")
+	}
+	configPath := commandConfigPath(configFlag)
+	if _, err := os.Stat(configPath); err != nil {
+		failWith(exitConfigMissing, []string{"config", configPath}, fmt.Sprintf("Error: config file not found: %s", configPath))
+	}
+	backupPath := configPath + ".bak"
+
+	var migrated []string
+	err = updateRawConfig(configPath, false, func(raw map[string]json.RawMessage) error {
+		for user, value := range raw {
+			updated, err := migrateEntry(value)
+			if err != nil {
+				return fmt.Errorf("invalid entry for user '%s': %v", user, err)
+			}
+			if !sameJSON(updated, value) {
+				raw[user] = updated
+				migrated = append(migrated, user)
+			}
+		}
+		if len(migrated) == 0 {
+			return errAlreadyMigrated
+		}
+
+		// The file hasn't been touched yet, so this is the original
+		if _, err := os.Stat(backupPath); err == nil {
+			return fmt.Errorf("backup file %s already exists, move it out of the way first", backupPath)
+		}
+		data, err := os.ReadFile(configPath)
+		if err != nil {
+			return err
+		}
+		if err := writeFileAtomic(backupPath, data, 0600); err != nil {
+			return fmt.Errorf("could not back up config file: %v", err)
+		}
+		return nil
+	})
+	if errors.Is(err, errAlreadyMigrated) {
+		fmt.Printf("✅ %s is already migrated\n", configPath)
+		return
+	}
+	if err != nil {
+		fatal(fmt.Sprintf("Error: %v", err))
+	}
+
+	sort.Strings(migrated)
+	for _, user := range migrated {
+		fmt.Printf("🔄 %s\n", user)
+	}
+	fmt.Printf("✅ Migrated %d user(s) in %s (backup in %s)\n", len(migrated), configPath, backupPath)
+}
+
+// migrateEntry returns the object form of a raw config value: otpauth:// URIs
+// are expanded, with their issuer and account name, and the algorithm, digits
+// and period (or counter) that apply are written out. A setting a plain secret
+// leaves to TOTP_ALGORITHM, TOTP_DIGITS or TOTP_PERIOD is left out instead,
+// so it stays a default rather than the value of the run that migrated it.
+// Fields of an existing object that aren't settings are kept.
+func migrateEntry(value json.RawMessage) (json.RawMessage, error) {
+	entry, err := config.ParseEntry(value)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(value, &fields); err != nil {
+		// A plain secret or URI string
+		fields = make(map[string]json.RawMessage)
+	}
+	opts := entry.Options()
+	settings := map[string]any{
+		"secret":    entry.Secret,
+		"algorithm": opts.Algorithm,
+	}
+	if entry.IsRotating() {
+		settings["secret"] = entry.Secrets
+	}
+	if entry.Issuer != "" {
+		settings["issuer"] = entry.Issuer
+	}
+	if entry.Label != "" {
+		settings["label"] = entry.Label
+	}
+	switch {
+	case entry.IsHOTP():
+		settings["digits"] = opts.Digits
+		settings["counter"] = *entry.Counter
+		delete(fields, "period")
+	case entry.IsSteam():
+		settings["type"] = config.SteamType
+		settings["period"] = opts.Period
+		delete(fields, "digits")
+	default:
+		settings["digits"] = opts.Digits
+		settings["period"] = opts.Period
+	}
+	if !entry.FromURI {
+		if entry.Algorithm == "" && envDefaults.Algorithm != "" {
+			delete(settings, "algorithm")
+		}
+		if entry.Digits == 0 && envDefaults.Digits != 0 {
+			delete(settings, "digits")
+		}
+		if entry.Period == 0 && envDefaults.Period != 0 {
+			delete(settings, "period")
+		}
+	}
+	for key, setting := range settings {
+		if fields[key], err = config.Marshal(setting, ""); err != nil {
+			return nil, err
+		}
+	}
+
+	return config.Marshal(fields, "")
+}
+
+// sameJSON reports whether two JSON values are equal, ignoring formatting
+// and key order
+func sameJSON(a, b json.RawMessage) bool {
+	var va, vb any
+	if json.Unmarshal(a, &va) != nil || json.Unmarshal(b, &vb) != nil {
+		return false
+	}
+	return reflect.DeepEqual(va, vb)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+)
+
+// osc52Copy sends codes to the terminal's clipboard with the OSC 52 escape
+// sequence instead of running a clipboard tool, set by --osc52 or over SSH
+// without a display
+var osc52Copy bool
+
+// terminalDevice is where OSC 52 sequences are written. It's the terminal
+// itself rather than stdout, so they never end up in captured output.
+func terminalDevice() string {
+	if runtime.GOOS == "windows" {
+		return "CONOUT$"
+	}
+	return "/dev/tty"
+}
+
+// hasTerminalDevice reports whether there's a terminal to send OSC 52 to
+func hasTerminalDevice() bool {
+	f, err := os.OpenFile(terminalDevice(), os.O_WRONLY, 0)
+	if err != nil {
+		return false
+	}
+	f.Close()
+	return true
+}
+
+// usingOSC52 reports whether copies go through the terminal: with --osc52,
+// or over SSH without a display as long as there's a terminal to write to
+func usingOSC52() bool {
+	return osc52Copy || (sshWithoutDisplay() && hasTerminalDevice())
+}
+
+// copiedMessage confirms a copy, saying where it went
+func copiedMessage() string {
+	if usingOSC52() {
+		return "📋 Sent to your terminal's clipboard (OSC 52)"
+	}
+	return "📋 Copied to clipboard"
+}
+
+// osc52Sequence returns the escape sequence that sets the clipboard, and the
+// primary selection too if asked, to text. Inside tmux or screen it's wrapped
+// in their passthrough sequence so it reaches the outer terminal.
+func osc52Sequence(text string, primary bool) string {
+	targets := "c"
+	if primary {
+		targets += "p"
+	}
+	seq := "\x1b]52;" + targets + ";" + base64.StdEncoding.EncodeToString([]byte(text)) + "\a"
+
+	switch {
+	case os.Getenv("TMUX") != "":
+		// tmux passes on DCS content with its escape characters doubled
+		return "\x1bPtmux;" + strings.ReplaceAll(seq, "\x1b", "\x1b\x1b") + "\x1b\\"
+	case os.Getenv("STY") != "":
+		return "\x1bP" + seq + "\x1b\\"
+	}
+	return seq
+}
+
+// copyOSC52 asks the terminal to put text on its clipboard. Whether the
+// terminal supports it can't be told, so a successful write is taken as a
+// successful copy.
+func copyOSC52(text string) error {
+	tty, err := os.OpenFile(terminalDevice(), os.O_WRONLY, 0)
+	if err != nil {
+		return fmt.Errorf("OSC 52 needs a terminal: %v", err)
+	}
+	defer tty.Close()
+	if _, err := tty.WriteString(osc52Sequence(text, copyPrimary)); err != nil {
+		return fmt.Errorf("OSC 52: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"runtime"
+)
+
+// pasteCommand returns the command that prints the clipboard's contents,
+// for the platforms where copyToClipboard's tool has a counterpart. There
+// is none for a custom clipboard command or under WSL.
+func pasteCommand() (*exec.Cmd, bool) {
+	if command, _ := customClipboardCmd(); command != "" {
+		return nil, false
+	}
+
+	switch runtime.GOOS {
+	case "darwin":
+		return exec.Command("pbpaste"), true
+	case "linux":
+		if isWSL() {
+			return nil, false
+		} else if _, err := exec.LookPath("wl-paste"); err == nil && os.Getenv("WAYLAND_DISPLAY") != "" {
+			return exec.Command("wl-paste", "--no-newline"), true
+		} else if _, err := exec.LookPath("xclip"); err == nil {
+			return exec.Command("xclip", "-selection", "clipboard", "-o"), true
+		} else if _, err := exec.LookPath("xsel"); err == nil {
+			return exec.Command("xsel", "--clipboard", "--output"), true
+		}
+		return nil, false
+	case "windows":
+		if path, err := exec.LookPath("powershell.exe"); err == nil {
+			return exec.Command(path, "-NoProfile", "-NonInteractive", "-Command", getClipboardScript), true
+		}
+		return nil, false
+	default:
+		return nil, false
+	}
+}
+
+// getClipboardScript prints the Windows clipboard as UTF-8 without adding a
+// trailing newline
+const getClipboardScript = "[Console]::OutputEncoding = [Text.Encoding]::UTF8; [Console]::Out.Write((Get-Clipboard -Raw))"
+
+// readClipboard returns the clipboard's text contents, and false if they
+// can't be read (no paste tool, an empty clipboard or non-text contents)
+func readClipboard() (string, bool) {
+	cmd, ok := pasteCommand()
+	if !ok {
+		return "", false
+	}
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	if err := runWithTimeout(cmd, clipboardTimeout); err != nil {
+		return "", false
+	}
+	return stdout.String(), true
+}
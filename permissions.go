@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+
+	"github.com/nsvirk/totp-cli/config"
+)
+
+// strictPermissions turns the insecure permissions warning into an error,
+// set by --strict
+var strictPermissions bool
+
+// permissionsChecked records the config files already checked, so each is
+// only warned about once per run
+var permissionsChecked = make(map[string]bool)
+
+// checkConfigPermissions warns when a plaintext config file can be read or
+// written by other users, with the chmod that fixes it. In strict mode it's
+// an error instead. Encrypted files are fine, and Windows is skipped since
+// its ACLs don't map to Unix mode bits.
+func checkConfigPermissions(path string) error {
+	if runtime.GOOS == "windows" || permissionsChecked[path] {
+		return nil
+	}
+	permissionsChecked[path] = true
+
+	info, err := os.Stat(path)
+	if err != nil || info.Mode().Perm()&0077 == 0 {
+		return nil
+	}
+	if data, err := os.ReadFile(path); err == nil && config.IsEncrypted(data) {
+		return nil
+	}
+	message := fmt.Sprintf("%s is accessible by other users (mode %04o), fix it with: chmod 600 %s", path, info.Mode().Perm(), path)
+	if strictPermissions {
+		return fmt.Errorf("%s", message)
+	}
+	warn(message)
+	return nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// porcelainHelperEnvVar makes the test binary run main with the JSON list
+// of arguments it holds, see TestPorcelainHelperProcess
+const porcelainHelperEnvVar = "TOTP_TEST_PORCELAIN_ARGS"
+
+// TestPorcelainHelperProcess isn't a test of its own: run by
+// TestPorcelainErrorFields, it runs the CLI, which exits
+func TestPorcelainHelperProcess(t *testing.T) {
+	value := os.Getenv(porcelainHelperEnvVar)
+	if value == "" {
+		t.Skip("only runs as a helper process")
+	}
+	var args []string
+	if err := json.Unmarshal([]byte(value), &args); err != nil {
+		t.Fatal(err)
+	}
+	os.Args = append([]string{"totp"}, args...)
+	main()
+	os.Exit(0)
+}
+
+// parsePorcelain splits a porcelain line into its tag, kind and key=value
+// fields, unquoting quoted values
+func parsePorcelain(t *testing.T, line string) (string, map[string]string) {
+	t.Helper()
+	tag, rest, ok := strings.Cut(line, ": ")
+	if !ok {
+		t.Fatalf("%q has no tag", line)
+	}
+	kind, rest, _ := strings.Cut(rest, " ")
+	fields := make(map[string]string)
+	for rest != "" {
+		key, value, ok := strings.Cut(rest, "=")
+		if !ok {
+			t.Fatalf("%q: expected key=value at %q", line, rest)
+		}
+		if strings.HasPrefix(value, `"`) {
+			quoted, err := strconv.QuotedPrefix(value)
+			if err != nil {
+				t.Fatalf("%q: bad quoting at %q: %v", line, value, err)
+			}
+			rest = strings.TrimPrefix(value[len(quoted):], " ")
+			value, _ = strconv.Unquote(quoted)
+		} else {
+			value, rest, _ = strings.Cut(value, " ")
+		}
+		fields[key] = value
+	}
+	return tag + ": " + kind, fields
+}
+
+func TestPorcelainErrorFields(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "my config.json")
+	if err := os.WriteFile(configPath, []byte(`{"github": "JBSWY3DPEHPK3PXP", "gitlab": "JBSWY3DPEHPK3PXP"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(dir, "missing.json")
+
+	for _, tt := range []struct {
+		args   []string
+		code   int
+		kind   string
+		fields map[string]string
+	}{
+		{[]string{"bitbucket"}, exitUserNotFound, "error: user_not_found", map[string]string{"user": "bitbucket", "config": configPath}},
+		{[]string{"git"}, exitUserNotFound, "error: user_not_found", map[string]string{"user": "git", "matches": "github,gitlab"}},
+		{[]string{"remove", "bitbucket", "--config", configPath}, exitUserNotFound, "error: user_not_found", map[string]string{"user": "bitbucket", "config": configPath}},
+		{[]string{"github", "--config", missing}, exitConfigMissing, "error: config_missing", map[string]string{"config": missing}},
+		{[]string{"verify", "github", "123456", "--config", missing}, exitConfigMissing, "error: config_missing", map[string]string{"config": missing}},
+	} {
+		args := append(tt.args, "--porcelain")
+		if tt.args[0] == "bitbucket" || tt.args[0] == "git" {
+			args = append(args, "--config", configPath)
+		}
+		encoded, _ := json.Marshal(args)
+		cmd := exec.Command(os.Args[0], "-test.run=^TestPorcelainHelperProcess$")
+		cmd.Env = append(os.Environ(), porcelainHelperEnvVar+"="+string(encoded), configCmdEnvVar+"=")
+		out, err := cmd.Output()
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) || exitErr.ExitCode() != tt.code {
+			t.Errorf("%v: exit %v, want %d (stdout %q)", tt.args, err, tt.code, out)
+			continue
+		}
+		line, _, _ := strings.Cut(string(exitErr.Stderr), "\n")
+		kind, fields := parsePorcelain(t, line)
+		if kind != tt.kind {
+			t.Errorf("%v: %q, want %s", tt.args, line, tt.kind)
+		}
+		if fields["msg"] == "" {
+			t.Errorf("%v: %q has no msg", tt.args, line)
+		}
+		for key, want := range tt.fields {
+			if fields[key] != want {
+				t.Errorf("%v: %s = %q in %q, want %q", tt.args, key, fields[key], line, want)
+			}
+		}
+	}
+}
+
+func TestPorcelainFields(t *testing.T) {
+	got := porcelainFields([]string{"user", "work/github", "config", "/home/me/my config.json", "issuer", "", "note", `a"b`})
+	want := ` user=work/github config="/home/me/my config.json" issuer="" note="a\"b"`
+	if got != want {
+		t.Errorf("porcelainFields = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// pruneCandidate is a user prune would remove, and why
+type pruneCandidate struct {
+	User   string
+	Reason string
+}
+
+// runPrune removes the users of the config file whose entries can't produce
+// a code, after confirmation, and offers to resolve user IDs that differ
+// only by case. With --dry-run it only reports what it would do.
+func runPrune(args []string) {
+	var configFlag string
+	var dryRun, yes bool
+	positional, err := parseCommandArgs(args,
+		map[string]*bool{"--dry-run": &dryRun, "--yes": &yes},
+		map[string]*string{"--config": &configFlag})
+	if err != nil {
+		fatal(fmt.Sprintf("Error: %v", err))
+	}
+	if len(positional) != 0 {
+		fatal("Usage: prune [--dry-run] [--yes] [--config <path>]")
+	}
+	configPath := commandConfigPath(configFlag)
+	if _, err := os.Stat(configPath); err != nil {
+		failWith(exitConfigMissing, []string{"config", configPath}, fmt.Sprintf("Error: config file not found: %s", configPath))
+	}
+	interactive := isTerminal(os.Stdin) && !yes
+	if !dryRun && !yes && !isTerminal(os.Stdin) {
+		fatal("Error: prune asks for confirmation, use --yes when not running from a terminal")
+	}
+
+	raw, err := readRawConfig(configPath)
+	if err != nil {
+		fatal(fmt.Sprintf("Error: %v", err))
+	}
+	candidates := brokenEntries(raw)
+	for _, candidate := range candidates {
+		fmt.Printf("💔 %s: %s\n", candidate.User, candidate.Reason)
+	}
+
+	// Users that differ only by case can't all be reached by a normal lookup.
+	// Only a person can tell which one to keep.
+	reader := bufio.NewReader(os.Stdin)
+	for _, keys := range caseCollisions(raw, candidates) {
+		fmt.Printf("🔀 User IDs differ only by case: %s\n", strings.Join(keys, ", "))
+		if dryRun || !interactive {
+			continue
+		}
+		for i, key := range keys {
+			fmt.Fprintf(os.Stderr, "  %2d) %s\n", i+1, key)
+		}
+		fmt.Fprintf(os.Stderr, "Keep which? [1-%d, Enter keeps all]: ", len(keys))
+		line, _ := reader.ReadString('\n')
+		choice := strings.TrimSpace(line)
+		if choice == "" {
+			continue
+		}
+		n, err := strconv.Atoi(choice)
+		if err != nil || n < 1 || n > len(keys) {
+			fatal(fmt.Sprintf("Error: invalid selection '%s'", choice))
+		}
+		for i, key := range keys {
+			if i != n-1 {
+				candidates = append(candidates, pruneCandidate{User: key, Reason: fmt.Sprintf("keeping '%s'", keys[n-1])})
+			}
+		}
+	}
+
+	if len(candidates) == 0 {
+		fmt.Printf("✅ Nothing to prune in %s\n", configPath)
+		return
+	}
+	if dryRun {
+		fmt.Printf("\n%d user(s) would be removed from %s (dry run, nothing changed)\n", len(candidates), configPath)
+		return
+	}
+	if interactive {
+		fmt.Fprintf(os.Stderr, "Remove %d user(s) from %s? [y/N]: ", len(candidates), configPath)
+		line, _ := reader.ReadString('\n')
+		if answer := strings.ToLower(strings.TrimSpace(line)); answer != "y" && answer != "yes" {
+			fmt.Println("Nothing removed")
+			return
+		}
+	}
+
+	var removed []string
+	err = updateRawConfig(configPath, false, func(raw map[string]json.RawMessage) error {
+		for _, candidate := range candidates {
+			if _, ok := raw[candidate.User]; ok {
+				delete(raw, candidate.User)
+				removed = append(removed, candidate.User)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		fatal(fmt.Sprintf("Error: %v", err))
+	}
+	for _, user := range removed {
+		fmt.Printf("🗑️ Removed '%s'\n", user)
+	}
+	fmt.Printf("✅ Pruned %d user(s) from %s\n", len(removed), configPath)
+}
+
+// brokenEntries returns the users whose entries don't parse or can't
+// generate a code, in sorted order
+func brokenEntries(raw map[string]json.RawMessage) []pruneCandidate {
+	users := make([]string, 0, len(raw))
+	for user := range raw {
+		users = append(users, user)
+	}
+	sort.Strings(users)
+
+	var broken []pruneCandidate
+	for _, user := range users {
+		entry, err := parseEntry(raw[user])
+		if err == nil {
+			err = entry.Check()
+		}
+		if err != nil {
+			broken = append(broken, pruneCandidate{User: user, Reason: err.Error()})
+		}
+	}
+	return broken
+}
+
+// caseCollisions returns the groups of user IDs that differ only by case,
+// leaving out users that are already being removed
+func caseCollisions(raw map[string]json.RawMessage, removing []pruneCandidate) [][]string {
+	skip := make(map[string]bool, len(removing))
+	for _, candidate := range removing {
+		skip[candidate.User] = true
+	}
+	byLower := make(map[string][]string)
+	for user := range raw {
+		if !skip[user] {
+			byLower[strings.ToLower(user)] = append(byLower[strings.ToLower(user)], user)
+		}
+	}
+
+	var groups [][]string
+	for _, keys := range byLower {
+		if len(keys) > 1 {
+			sort.Strings(keys)
+			groups = append(groups, keys)
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i][0] < groups[j][0] })
+	return groups
+}
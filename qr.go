@@ -0,0 +1,506 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// qrVersion describes the error correction layout for one QR code version at
+// error correction level M
+type qrVersion struct {
+	ecPerBlock int   // EC codewords per block
+	blocks     []int // data codewords of each block
+	alignment  []int // alignment pattern center coordinates
+}
+
+// qrVersions lists versions 1-20 at level M, enough for any otpauth URI
+var qrVersions = []qrVersion{
+	{10, []int{16}, nil},
+	{16, []int{28}, []int{6, 18}},
+	{26, []int{44}, []int{6, 22}},
+	{18, []int{32, 32}, []int{6, 26}},
+	{24, []int{43, 43}, []int{6, 30}},
+	{16, []int{27, 27, 27, 27}, []int{6, 34}},
+	{18, []int{31, 31, 31, 31}, []int{6, 22, 38}},
+	{22, []int{38, 38, 39, 39}, []int{6, 24, 42}},
+	{22, []int{36, 36, 36, 37, 37}, []int{6, 26, 46}},
+	{26, []int{43, 43, 43, 43, 44}, []int{6, 28, 50}},
+	{30, []int{50, 51, 51, 51, 51}, []int{6, 30, 54}},
+	{22, []int{36, 36, 36, 36, 36, 36, 37, 37}, []int{6, 32, 58}},
+	{22, []int{37, 37, 37, 37, 37, 37, 37, 37, 38}, []int{6, 34, 62}},
+	{24, []int{40, 40, 40, 40, 41, 41, 41, 41, 41}, []int{6, 26, 46, 66}},
+	{24, []int{41, 41, 41, 41, 41, 42, 42, 42, 42, 42}, []int{6, 26, 48, 70}},
+	{28, []int{45, 45, 45, 45, 45, 45, 45, 46, 46, 46}, []int{6, 26, 50, 74}},
+	{28, []int{46, 46, 46, 46, 46, 46, 46, 46, 46, 46, 47}, []int{6, 30, 54, 78}},
+	{26, []int{43, 43, 43, 43, 43, 43, 43, 43, 43, 44, 44, 44, 44}, []int{6, 30, 56, 82}},
+	{26, []int{44, 44, 44, 45, 45, 45, 45, 45, 45, 45, 45, 45, 45, 45}, []int{6, 30, 58, 86}},
+	{26, []int{41, 41, 41, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42}, []int{6, 34, 62, 90}},
+}
+
+// qrCode is a square matrix of modules, true meaning dark
+type qrCode struct {
+	size       int
+	modules    [][]bool
+	isFunction [][]bool
+}
+
+// encodeQR builds a QR code for text in byte mode at error correction level M
+func encodeQR(text string) (*qrCode, error) {
+	data := []byte(text)
+
+	// Pick the smallest version that fits the data
+	version := 0
+	for v := 1; v <= len(qrVersions); v++ {
+		countBits := 8
+		if v >= 10 {
+			countBits = 16
+		}
+		capacity := 0
+		for _, n := range qrVersions[v-1].blocks {
+			capacity += n
+		}
+		if 4+countBits+8*len(data) <= capacity*8 {
+			version = v
+			break
+		}
+	}
+	if version == 0 {
+		return nil, fmt.Errorf("text is too long for a QR code (%d bytes)", len(data))
+	}
+	info := qrVersions[version-1]
+
+	codewords := qrDataCodewords(data, version, info)
+	blocks := qrAddErrorCorrection(codewords, info)
+
+	qr := newQRCode(version)
+	qr.drawFunctionPatterns(version, info)
+	qr.drawCodewords(blocks)
+
+	// Apply the mask with the lowest penalty
+	best, bestPenalty := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		qr.applyMask(mask)
+		qr.drawFormatBits(mask)
+		if penalty := qr.penalty(); bestPenalty < 0 || penalty < bestPenalty {
+			best, bestPenalty = mask, penalty
+		}
+		qr.applyMask(mask) // masking is its own inverse
+	}
+	qr.applyMask(best)
+	qr.drawFormatBits(best)
+	return qr, nil
+}
+
+// qrDataCodewords encodes data as a byte mode segment padded to the capacity of the version
+func qrDataCodewords(data []byte, version int, info qrVersion) []byte {
+	capacity := 0
+	for _, n := range info.blocks {
+		capacity += n
+	}
+
+	var bits []bool
+	appendBits := func(value, count int) {
+		for i := count - 1; i >= 0; i-- {
+			bits = append(bits, (value>>i)&1 == 1)
+		}
+	}
+	countBits := 8
+	if version >= 10 {
+		countBits = 16
+	}
+	appendBits(0x4, 4) // byte mode
+	appendBits(len(data), countBits)
+	for _, b := range data {
+		appendBits(int(b), 8)
+	}
+
+	// Terminator, then pad to a whole byte
+	appendBits(0, min(4, capacity*8-len(bits)))
+	for len(bits)%8 != 0 {
+		bits = append(bits, false)
+	}
+
+	codewords := make([]byte, 0, capacity)
+	for i := 0; i < len(bits); i += 8 {
+		var b byte
+		for j := 0; j < 8; j++ {
+			if bits[i+j] {
+				b |= 1 << (7 - j)
+			}
+		}
+		codewords = append(codewords, b)
+	}
+	for pad := byte(0xEC); len(codewords) < capacity; pad ^= 0xEC ^ 0x11 {
+		codewords = append(codewords, pad)
+	}
+	return codewords
+}
+
+// qrAddErrorCorrection splits the data into blocks, appends Reed-Solomon
+// codewords to each, and interleaves them in transmission order
+func qrAddErrorCorrection(data []byte, info qrVersion) []byte {
+	generator := rsGenerator(info.ecPerBlock)
+
+	var dataBlocks, ecBlocks [][]byte
+	offset := 0
+	for _, n := range info.blocks {
+		block := data[offset : offset+n]
+		offset += n
+		dataBlocks = append(dataBlocks, block)
+		ecBlocks = append(ecBlocks, rsRemainder(block, generator))
+	}
+
+	var result []byte
+	longest := info.blocks[len(info.blocks)-1]
+	for i := 0; i < longest; i++ {
+		for _, block := range dataBlocks {
+			if i < len(block) {
+				result = append(result, block[i])
+			}
+		}
+	}
+	for i := 0; i < info.ecPerBlock; i++ {
+		for _, block := range ecBlocks {
+			result = append(result, block[i])
+		}
+	}
+	return result
+}
+
+// gfMultiply multiplies two elements of GF(256) with the QR code polynomial 0x11D
+func gfMultiply(x, y byte) byte {
+	var z byte
+	for i := 7; i >= 0; i-- {
+		carry := z >> 7
+		z = (z << 1) ^ (carry * 0x1D)
+		z ^= ((y >> i) & 1) * x
+	}
+	return z
+}
+
+// rsGenerator returns the coefficients of the Reed-Solomon generator
+// polynomial of the given degree, highest power first (excluding the leading 1)
+func rsGenerator(degree int) []byte {
+	result := make([]byte, degree)
+	result[degree-1] = 1
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := 0; j < degree; j++ {
+			result[j] = gfMultiply(result[j], root)
+			if j+1 < degree {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = gfMultiply(root, 0x02)
+	}
+	return result
+}
+
+// rsRemainder computes the Reed-Solomon error correction codewords for data
+func rsRemainder(data, generator []byte) []byte {
+	result := make([]byte, len(generator))
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i := range result {
+			result[i] ^= gfMultiply(generator[i], factor)
+		}
+	}
+	return result
+}
+
+// newQRCode allocates an empty matrix for the given version
+func newQRCode(version int) *qrCode {
+	size := version*4 + 17
+	qr := &qrCode{size: size}
+	qr.modules = make([][]bool, size)
+	qr.isFunction = make([][]bool, size)
+	for i := range qr.modules {
+		qr.modules[i] = make([]bool, size)
+		qr.isFunction[i] = make([]bool, size)
+	}
+	return qr
+}
+
+// setFunction sets a module that belongs to a function pattern
+func (qr *qrCode) setFunction(x, y int, dark bool) {
+	qr.modules[y][x] = dark
+	qr.isFunction[y][x] = true
+}
+
+// drawFunctionPatterns draws the finder, timing and alignment patterns and
+// reserves the format and version areas
+func (qr *qrCode) drawFunctionPatterns(version int, info qrVersion) {
+	size := qr.size
+
+	// Timing patterns
+	for i := 0; i < size; i++ {
+		qr.setFunction(6, i, i%2 == 0)
+		qr.setFunction(i, 6, i%2 == 0)
+	}
+
+	// Finder patterns with their separators
+	for _, corner := range [][2]int{{3, 3}, {size - 4, 3}, {3, size - 4}} {
+		for dy := -4; dy <= 4; dy++ {
+			for dx := -4; dx <= 4; dx++ {
+				x, y := corner[0]+dx, corner[1]+dy
+				if x < 0 || x >= size || y < 0 || y >= size {
+					continue
+				}
+				dist := max(abs(dx), abs(dy))
+				qr.setFunction(x, y, dist != 2 && dist != 4)
+			}
+		}
+	}
+
+	// Alignment patterns, skipping the three that would overlap finders
+	positions := info.alignment
+	for i, cx := range positions {
+		for j, cy := range positions {
+			last := len(positions) - 1
+			if (i == 0 && j == 0) || (i == 0 && j == last) || (i == last && j == 0) {
+				continue
+			}
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					qr.setFunction(cx+dx, cy+dy, max(abs(dx), abs(dy)) != 1)
+				}
+			}
+		}
+	}
+
+	// Reserve the format areas; the real bits are drawn after masking
+	qr.drawFormatBits(0)
+
+	// Version information for version 7 and up
+	if version >= 7 {
+		rem := version
+		for i := 0; i < 12; i++ {
+			rem = (rem << 1) ^ ((rem >> 11) * 0x1F25)
+		}
+		bits := version<<12 | rem
+		for i := 0; i < 18; i++ {
+			dark := (bits>>i)&1 == 1
+			a, b := size-11+i%3, i/3
+			qr.setFunction(a, b, dark)
+			qr.setFunction(b, a, dark)
+		}
+	}
+}
+
+// drawFormatBits draws both copies of the format information for level M
+// and the given mask
+func (qr *qrCode) drawFormatBits(mask int) {
+	size := qr.size
+	data := 0<<3 | mask // level M is encoded as 00
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = (rem << 1) ^ ((rem >> 9) * 0x537)
+	}
+	bits := (data<<10 | rem) ^ 0x5412
+	bit := func(i int) bool { return (bits>>i)&1 == 1 }
+
+	// First copy, around the top-left finder
+	for i := 0; i <= 5; i++ {
+		qr.setFunction(8, i, bit(i))
+	}
+	qr.setFunction(8, 7, bit(6))
+	qr.setFunction(8, 8, bit(7))
+	qr.setFunction(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		qr.setFunction(14-i, 8, bit(i))
+	}
+
+	// Second copy, split between the other two finders
+	for i := 0; i < 8; i++ {
+		qr.setFunction(size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		qr.setFunction(8, size-15+i, bit(i))
+	}
+	qr.setFunction(8, size-8, true) // always dark
+}
+
+// drawCodewords places the codeword bits in the zigzag pattern over all
+// modules that aren't part of a function pattern
+func (qr *qrCode) drawCodewords(data []byte) {
+	size := qr.size
+	i := 0
+	for right := size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5 // skip the vertical timing pattern
+		}
+		for vert := 0; vert < size; vert++ {
+			for j := 0; j < 2; j++ {
+				x := right - j
+				upward := (right+1)&2 == 0
+				y := vert
+				if upward {
+					y = size - 1 - vert
+				}
+				if qr.isFunction[y][x] || i >= len(data)*8 {
+					continue
+				}
+				qr.modules[y][x] = (data[i>>3]>>(7-i&7))&1 == 1
+				i++
+			}
+		}
+	}
+}
+
+// applyMask XORs the data modules with one of the eight mask patterns
+func (qr *qrCode) applyMask(mask int) {
+	for y := 0; y < qr.size; y++ {
+		for x := 0; x < qr.size; x++ {
+			if qr.isFunction[y][x] {
+				continue
+			}
+			var invert bool
+			switch mask {
+			case 0:
+				invert = (x+y)%2 == 0
+			case 1:
+				invert = y%2 == 0
+			case 2:
+				invert = x%3 == 0
+			case 3:
+				invert = (x+y)%3 == 0
+			case 4:
+				invert = (x/3+y/2)%2 == 0
+			case 5:
+				invert = x*y%2+x*y%3 == 0
+			case 6:
+				invert = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				invert = ((x+y)%2+x*y%3)%2 == 0
+			}
+			if invert {
+				qr.modules[y][x] = !qr.modules[y][x]
+			}
+		}
+	}
+}
+
+// penalty scores the matrix using the four rules from the QR code
+// specification; lower scores are easier to scan
+func (qr *qrCode) penalty() int {
+	size := qr.size
+	score := 0
+	get := func(x, y int, vertical bool) bool {
+		if vertical {
+			return qr.modules[x][y]
+		}
+		return qr.modules[y][x]
+	}
+
+	finderLike := []bool{true, false, true, true, true, false, true}
+	for _, vertical := range []bool{false, true} {
+		for y := 0; y < size; y++ {
+			// Runs of five or more modules of the same color
+			run := 1
+			for x := 1; x < size; x++ {
+				if get(x, y, vertical) == get(x-1, y, vertical) {
+					run++
+					continue
+				}
+				if run >= 5 {
+					score += run - 2
+				}
+				run = 1
+			}
+			if run >= 5 {
+				score += run - 2
+			}
+
+			// Finder-like patterns with four light modules on either side
+			for x := 0; x+7 <= size; x++ {
+				match := true
+				for k, dark := range finderLike {
+					if get(x+k, y, vertical) != dark {
+						match = false
+						break
+					}
+				}
+				if !match {
+					continue
+				}
+				before, after := true, true
+				for k := 1; k <= 4; k++ {
+					if x-k >= 0 && get(x-k, y, vertical) {
+						before = false
+					}
+					if x+6+k < size && get(x+6+k, y, vertical) {
+						after = false
+					}
+				}
+				if before || after {
+					score += 40
+				}
+			}
+		}
+	}
+
+	// 2x2 blocks of the same color
+	for y := 0; y < size-1; y++ {
+		for x := 0; x < size-1; x++ {
+			c := qr.modules[y][x]
+			if c == qr.modules[y][x+1] && c == qr.modules[y+1][x] && c == qr.modules[y+1][x+1] {
+				score += 3
+			}
+		}
+	}
+
+	// Balance of dark and light modules
+	dark := 0
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			if qr.modules[y][x] {
+				dark++
+			}
+		}
+	}
+	percent := dark * 100 / (size * size)
+	score += abs(percent-50) / 5 * 10
+	return score
+}
+
+// abs returns the absolute value of an int
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// renderQR draws the QR code with ANSI colored half blocks, two module rows
+// per line, surrounded by a quiet zone. Colors are set explicitly so the code
+// scans on both light and dark terminal themes.
+func renderQR(qr *qrCode) string {
+	const quiet = 2
+	dark := func(x, y int) bool {
+		x, y = x-quiet, y-quiet
+		if x < 0 || y < 0 || x >= qr.size || y >= qr.size {
+			return false
+		}
+		return qr.modules[y][x]
+	}
+
+	var b strings.Builder
+	total := qr.size + 2*quiet
+	for y := 0; y < total; y += 2 {
+		for x := 0; x < total; x++ {
+			// Foreground paints the top module, background the bottom one
+			fg, bg := 97, 107
+			if dark(x, y) {
+				fg = 30
+			}
+			if dark(x, y+1) {
+				bg = 40
+			}
+			fmt.Fprintf(&b, "\033[%d;%dm▀", fg, bg)
+		}
+		b.WriteString("\033[0m\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/nsvirk/totp-cli/config"
+)
+
+// runSearch lists the users whose IDs contain a substring, ignoring case,
+// without generating codes. With --code and a single match, that user's code
+// is shown as if it had been given by name.
+func runSearch(args []string) {
+	var configFlag string
+	var showCode, noCopy bool
+	positional, err := parseCommandArgs(args,
+		map[string]*bool{"--code": &showCode, "--no-copy": &noCopy},
+		map[string]*string{"--config": &configFlag})
+	if err != nil {
+		fatal(fmt.Sprintf("Error: %v", err))
+	}
+	if len(positional) != 1 || positional[0] == "" {
+		fatal("Usage: search <text> [--code] [--no-copy] [--config <path>]")
+	}
+	configPath, explicit, err := resolveConfigPath(configFlag)
+	if err != nil {
+		fatal(fmt.Sprintf("Error: %v", err))
+	}
+	cfg, err := loadConfig(configPath, explicit)
+	if err != nil {
+		failWith(configExitCode(err), []string{"config", configPath}, fmt.Sprintf("Error: %v", err))
+	}
+
+	caseInsensitiveConfig, collisions := createCaseInsensitiveMap(cfg)
+	reportCaseCollisions(collisions, false)
+	text := strings.ToLower(positional[0])
+	var matches []string
+	for lower, key := range caseInsensitiveConfig {
+		if strings.Contains(lower, text) {
+			matches = append(matches, key)
+		}
+	}
+	sort.Strings(matches)
+	if len(matches) == 0 {
+		failWith(exitUserNotFound, []string{"query", positional[0]}, fmt.Sprintf("No users match '%s'", positional[0]))
+	}
+
+	if showCode {
+		if len(matches) > 1 {
+			failWith(exitUserNotFound, []string{"query", positional[0], "matches", strings.Join(matches, ",")}, fmt.Sprintf("--code needs a single match, %d users match '%s'", len(matches), positional[0]),
+				fmt.Sprintf("Matching users: %s", strings.Join(matches, ", ")))
+		}
+		copyToClip := !noCopy
+		if copyToClip {
+			loadClipboardSettings(readSettings(configPath))
+		}
+		if _, disabled := clipboardDisabled(); disabled {
+			copyToClip = false
+		}
+		showCodes(matches, []config.Entry{cfg[matches[0]]}, configPath, codeOptions{copyToClip: copyToClip})
+		return
+	}
+
+	if jsonOutput {
+		printJSON(os.Stdout, matches)
+		return
+	}
+	for _, user := range matches {
+		fmt.Println(user)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/base32"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/nsvirk/totp-cli/totp"
+)
+
+// rfcSeeds are the ASCII keys of the RFC 6238 test vectors for each
+// algorithm. RFC 4226 uses the SHA1 one.
+var rfcSeeds = map[string]string{
+	"SHA1":   "12345678901234567890",
+	"SHA256": "12345678901234567890123456789012",
+	"SHA512": "1234567890123456789012345678901234567890123456789012345678901234",
+}
+
+// totpVectors are the test vectors of RFC 6238 appendix B: 8 digit codes
+// with a 30 second period at the given Unix times
+var totpVectors = []struct {
+	time      int64
+	algorithm string
+	code      string
+}{
+	{59, "SHA1", "94287082"},
+	{59, "SHA256", "46119246"},
+	{59, "SHA512", "90693936"},
+	{1111111109, "SHA1", "07081804"},
+	{1111111109, "SHA256", "68084774"},
+	{1111111109, "SHA512", "25091201"},
+	{1111111111, "SHA1", "14050471"},
+	{1111111111, "SHA256", "67062674"},
+	{1111111111, "SHA512", "99943326"},
+	{1234567890, "SHA1", "89005924"},
+	{1234567890, "SHA256", "91819424"},
+	{1234567890, "SHA512", "93441116"},
+	{2000000000, "SHA1", "69279037"},
+	{2000000000, "SHA256", "90698825"},
+	{2000000000, "SHA512", "38618901"},
+	{20000000000, "SHA1", "65353130"},
+	{20000000000, "SHA256", "77737706"},
+	{20000000000, "SHA512", "47863826"},
+}
+
+// hotpVectors are the 6 digit codes of RFC 4226 appendix D for counters 0-9
+var hotpVectors = []string{"755224", "287082", "359152", "969429", "338314", "254676", "287922", "162583", "399871", "520489"}
+
+// selftestResult is the JSON form of one test vector's outcome
+type selftestResult struct {
+	Name     string `json:"name"`
+	Expected string `json:"expected"`
+	Got      string `json:"got"`
+	Passed   bool   `json:"passed"`
+	Error    string `json:"error,omitempty"`
+}
+
+// runSelftest checks code generation against the published RFC 6238 and
+// RFC 4226 test vectors, also with the key in the base32hex alphabet,
+// exiting nonzero if any of them doesn't match
+func runSelftest(args []string) {
+	positional, err := parseCommandArgs(args, nil, nil)
+	if err != nil {
+		fatal(fmt.Sprintf("Error: %v", err))
+	}
+	if len(positional) != 0 {
+		fatal("Usage: selftest")
+	}
+
+	var results []selftestResult
+	check := func(name, expected, code string, err error) {
+		result := selftestResult{Name: name, Expected: expected, Got: code, Passed: err == nil && code == expected}
+		if err != nil {
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+	for _, v := range totpVectors {
+		secret := base32.StdEncoding.EncodeToString([]byte(rfcSeeds[v.algorithm]))
+		code, err := totp.Generate(secret, totp.Options{Digits: 8, Period: 30, Algorithm: v.algorithm, Time: time.Unix(v.time, 0)})
+		check(fmt.Sprintf("RFC 6238 %s t=%d", v.algorithm, v.time), v.code, code, err)
+	}
+	// The same SHA1 keys written in the base32hex alphabet must give the
+	// same codes
+	for _, v := range totpVectors {
+		if v.algorithm != "SHA1" {
+			continue
+		}
+		secret := base32.HexEncoding.EncodeToString([]byte(rfcSeeds[v.algorithm]))
+		code, err := totp.Generate(secret, totp.Options{Digits: 8, Period: 30, Algorithm: v.algorithm, Encoding: totp.EncodingBase32Hex, Time: time.Unix(v.time, 0)})
+		check(fmt.Sprintf("RFC 6238 %s t=%d (base32hex)", v.algorithm, v.time), v.code, code, err)
+	}
+	for counter, expected := range hotpVectors {
+		secret := base32.StdEncoding.EncodeToString([]byte(rfcSeeds["SHA1"]))
+		code, err := totp.GenerateHOTP(secret, uint64(counter), totp.Options{Digits: 6})
+		check(fmt.Sprintf("RFC 4226 SHA1 counter=%d", counter), expected, code, err)
+	}
+
+	failed := 0
+	for _, result := range results {
+		if !result.Passed {
+			failed++
+		}
+	}
+	if jsonOutput {
+		printJSON(os.Stdout, results)
+	} else {
+		for _, result := range results {
+			switch {
+			case result.Passed:
+				fmt.Printf("✅ %s: %s\n", result.Name, result.Got)
+			case result.Error != "":
+				fmt.Printf("❌ %s: %s\n", result.Name, result.Error)
+			default:
+				fmt.Printf("❌ %s: got %s, expected %s\n", result.Name, result.Got, result.Expected)
+			}
+		}
+		fmt.Printf("\n%d passed, %d failed\n", len(results)-failed, failed)
+	}
+	if failed > 0 {
+		os.Exit(exitError)
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nsvirk/totp-cli/config"
+)
+
+// Settings come from every config file, in merge order, and each file is
+// decoded once for the settings and the users together
+func TestReadSettings(t *testing.T) {
+	dir := t.TempDir()
+	first := filepath.Join(dir, "first.json")
+	second := filepath.Join(dir, "second.yaml")
+	if err := os.WriteFile(first, []byte(`{"_default": "github", "_audit": true, "github": "JBSWY3DPEHPK3PXP"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(second, []byte("_default: gitlab\ngitlab: JBSWY3DPEHPK3PXP\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	saved := extraConfigPaths
+	t.Cleanup(func() { extraConfigPaths = saved })
+	extraConfigPaths = []string{second}
+
+	settings := readSettings(first)
+	if got := defaultUser(settings); got != "gitlab" {
+		t.Errorf("defaultUser = %q, want the last file's gitlab", got)
+	}
+	if got := settings[config.DefaultKey]; len(got) != 2 || got[0].path != first || got[1].path != second {
+		t.Errorf("%s settings = %+v, want one from each file in order", config.DefaultKey, got)
+	}
+	if got := settings[config.AuditKey]; len(got) != 1 || string(got[0].value) != "true" {
+		t.Errorf("%s settings = %+v, want true from %s", config.AuditKey, got, first)
+	}
+
+	// The file is cached, so changing it doesn't change this run's view
+	if err := os.WriteFile(first, []byte(`{}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	raw, err := readRawConfig(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := raw["github"]; !ok || len(raw) != 1 {
+		t.Errorf("readRawConfig = %v, want only the cached github user", raw)
+	}
+}
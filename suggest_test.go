@@ -0,0 +1,70 @@
+package main
+
+import "testing"
+
+func TestLevenshtein(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"github", "github", 0},
+		{"", "abc", 3},
+		{"abc", "", 3},
+		{"githb", "github", 1},
+		{"gihtub", "github", 2},
+		{"kitten", "sitting", 3},
+		{"gitlab", "github", 2},
+		{"über", "uber", 1},
+	}
+	for _, tt := range tests {
+		if got := levenshtein([]rune(tt.a), []rune(tt.b)); got != tt.want {
+			t.Errorf("levenshtein(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+		if got := levenshtein([]rune(tt.b), []rune(tt.a)); got != tt.want {
+			t.Errorf("levenshtein(%q, %q) = %d, want %d", tt.b, tt.a, got, tt.want)
+		}
+	}
+}
+
+func TestClosestUser(t *testing.T) {
+	users := []string{"aws_prod", "aws_staging", "github", "gitlab", "home/router", "work/slack"}
+	tests := []struct {
+		userArg string
+		want    string
+	}{
+		{"githb", "github"},
+		{"GITHUB_", "github"},
+		{"gitlob", "gitlab"},
+		{"aws_prd", "aws_prod"},
+		{"aws_stagign", "aws_staging"},
+		// Grouped users are found by their leaf name too
+		{"slak", "work/slack"},
+		{"ruoter", "home/router"},
+		// Too far from anything to be a plausible typo
+		{"bitbucket", ""},
+		{"gxxlxb", ""},
+		// Short names only allow a single edit, and never a full rewrite
+		{"ab", ""},
+		{"x", ""},
+	}
+	for _, tt := range tests {
+		got, ok := closestUser(users, tt.userArg)
+		if tt.want == "" {
+			if ok {
+				t.Errorf("closestUser(%q) = %q, want no suggestion", tt.userArg, got)
+			}
+			continue
+		}
+		if !ok || got != tt.want {
+			t.Errorf("closestUser(%q) = %q, %t, want %q", tt.userArg, got, ok, tt.want)
+		}
+	}
+}
+
+// Ties go to the first user in sorted order
+func TestClosestUserTie(t *testing.T) {
+	if got, ok := closestUser([]string{"gitea", "gitee"}, "gite"); !ok || got != "gitea" {
+		t.Errorf("closestUser(gite) = %q, %t, want gitea", got, ok)
+	}
+}
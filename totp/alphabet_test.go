@@ -0,0 +1,53 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckAlphabetConfusables(t *testing.T) {
+	tests := []struct {
+		secret string
+		want   string
+	}{
+		{"JBSW0Y3DP", "character '0' at position 5 is not in the base32 alphabet (A-Z, 2-7), did you mean 'O'?"},
+		{"1BSWY3DP", "character '1' at position 1 is not in the base32 alphabet (A-Z, 2-7), did you mean 'I'?"},
+		{"JBSWY3DP8", "character '8' at position 9 is not in the base32 alphabet (A-Z, 2-7), did you mean 'B'?"},
+		// Positions count the characters as pasted, separators included
+		{"jbsw-y3d0", "character '0' at position 9 is not in the base32 alphabet (A-Z, 2-7), did you mean 'O'?"},
+		// Only the first bad character is reported
+		{"JB0W1Y3DP", "character '0' at position 3"},
+		// Characters without a likely meaning get no suggestion
+		{"JBSW9Y3DP", `character '9' at position 5 is not in the base32 alphabet (A-Z, 2-7)`},
+		{"JBSW!Y3DP", `character '!' at position 5 is not in the base32 alphabet (A-Z, 2-7)`},
+	}
+	for _, tt := range tests {
+		err := checkAlphabet(tt.secret)
+		if err == nil {
+			t.Errorf("checkAlphabet(%q) = nil, want an error", tt.secret)
+			continue
+		}
+		if !strings.HasPrefix(err.Error(), tt.want) {
+			t.Errorf("checkAlphabet(%q) = %q, want %q", tt.secret, err, tt.want)
+		}
+		if strings.HasSuffix(tt.want, ")") && strings.Contains(err.Error(), "did you mean") {
+			t.Errorf("checkAlphabet(%q) = %q, want no suggestion", tt.secret, err)
+		}
+	}
+}
+
+func TestCheckAlphabetValid(t *testing.T) {
+	for _, secret := range []string{"JBSWY3DPEHPK3PXP", "jbswy3dpehpk3pxp", "JBSW Y3DP-EHPK.3PXP", "MZXW6YQ="} {
+		if err := checkAlphabet(secret); err != nil {
+			t.Errorf("checkAlphabet(%q) = %v, want nil", secret, err)
+		}
+	}
+}
+
+func TestDecodeSecretNamesBadCharacter(t *testing.T) {
+	_, err := DecodeSecret("JBSWY3DPEHPK3PX0")
+	want := "invalid base32 secret: character '0' at position 16 is not in the base32 alphabet (A-Z, 2-7), did you mean 'O'?"
+	if err == nil || err.Error() != want {
+		t.Errorf("DecodeSecret error = %v, want %q", err, want)
+	}
+}
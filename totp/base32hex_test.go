@@ -0,0 +1,76 @@
+package totp
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGenerateBase32HexSecret(t *testing.T) {
+	tests := []struct {
+		time      int64
+		algorithm string
+		want      string
+	}{
+		{59, "SHA1", "94287082"},
+		{1111111109, "SHA1", "07081804"},
+		{1111111111, "SHA1", "14050471"},
+		{1234567890, "SHA1", "89005924"},
+		{2000000000, "SHA1", "69279037"},
+		{20000000000, "SHA1", "65353130"},
+		{59, "SHA256", "46119246"},
+		{59, "SHA512", "90693936"},
+	}
+	for _, tt := range tests {
+		secret := base32.HexEncoding.EncodeToString([]byte(rfcSeeds[tt.algorithm]))
+		opts := Options{Digits: 8, Period: 30, Algorithm: tt.algorithm, Encoding: EncodingBase32Hex, Time: time.Unix(tt.time, 0)}
+		got, err := Generate(secret, opts)
+		if err != nil {
+			t.Errorf("Generate(base32hex %s, t=%d): %v", tt.algorithm, tt.time, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Generate(base32hex %s, t=%d) = %s, want %s", tt.algorithm, tt.time, got, tt.want)
+		}
+	}
+}
+
+// The SHA1 key in base32hex, unpadded as most systems show it
+const rfcBase32HexSecret = "64P36D1L6ORJGE9G64P36D1L6ORJGE9G"
+
+func TestDecodeBase32HexSecretFormatting(t *testing.T) {
+	want := "12345678901234567890"
+	for _, secret := range []string{
+		rfcBase32HexSecret,
+		strings.ToLower(rfcBase32HexSecret),
+		"64p3-6d1l-6orj-ge9g-64p3-6d1l-6orj-ge9g",
+		// Unpadded, the 10-byte half of the key is 16 characters
+		"64P36D1L6ORJGE9G",
+	} {
+		got, err := DecodeBase32HexSecret(secret)
+		if err != nil {
+			t.Errorf("DecodeBase32HexSecret(%q): %v", secret, err)
+			continue
+		}
+		if !strings.HasPrefix(want, string(got)) {
+			t.Errorf("DecodeBase32HexSecret(%q) = %q, want a prefix of %q", secret, got, want)
+		}
+	}
+	if got, err := DecodeKey("C5H66", EncodingBase32Hex); err != nil || string(got) != "abc" {
+		t.Errorf("DecodeKey(C5H66, base32hex) = %q, %v, want \"abc\"", got, err)
+	}
+}
+
+func TestDecodeBase32HexSecretErrors(t *testing.T) {
+	// W-Z are standard base32 letters but not base32hex ones
+	_, err := DecodeBase32HexSecret("64P36D1W")
+	want := "invalid base32hex secret: character 'W' at position 8 is not in the base32hex alphabet (0-9, A-V)"
+	if err == nil || err.Error() != want {
+		t.Errorf("DecodeBase32HexSecret error = %v, want %q", err, want)
+	}
+	// A standard base32 secret decodes to different bytes, or not at all
+	if got, err := DecodeBase32HexSecret("JBSWY3DPEHPK3PXP"); err == nil {
+		t.Errorf("DecodeBase32HexSecret(JBSWY3DPEHPK3PXP) = %x, want an error", got)
+	}
+}
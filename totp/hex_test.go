@@ -0,0 +1,88 @@
+package totp
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The RFC 6238 SHA1 key, "12345678901234567890", in hex
+const rfcHexSecret = "3132333435363738393031323334353637383930"
+
+func TestGenerateHexSecret(t *testing.T) {
+	tests := []struct {
+		time      int64
+		algorithm string
+		want      string
+	}{
+		{59, "SHA1", "94287082"},
+		{1111111109, "SHA1", "07081804"},
+		{1234567890, "SHA1", "89005924"},
+		{59, "SHA256", "46119246"},
+		{2000000000, "SHA256", "90698825"},
+		{59, "SHA512", "90693936"},
+		{20000000000, "SHA512", "47863826"},
+	}
+	for _, tt := range tests {
+		secret := hex.EncodeToString([]byte(rfcSeeds[tt.algorithm]))
+		opts := Options{Digits: 8, Period: 30, Algorithm: tt.algorithm, Encoding: EncodingHex, Time: time.Unix(tt.time, 0)}
+		got, err := Generate(secret, opts)
+		if err != nil {
+			t.Errorf("Generate(hex %s, t=%d): %v", tt.algorithm, tt.time, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Generate(hex %s, t=%d) = %s, want %s", tt.algorithm, tt.time, got, tt.want)
+		}
+	}
+}
+
+func TestGenerateHOTPHexSecret(t *testing.T) {
+	got, err := GenerateHOTP(rfcHexSecret, 0, Options{Digits: 6, Encoding: EncodingHex})
+	if err != nil || got != "755224" {
+		t.Errorf("GenerateHOTP(hex, counter=0) = %s, %v, want 755224", got, err)
+	}
+}
+
+func TestDecodeHexSecretFormatting(t *testing.T) {
+	want := []byte("12345678901234567890")
+	for _, secret := range []string{
+		rfcHexSecret,
+		strings.ToUpper(rfcHexSecret),
+		"0x" + rfcHexSecret,
+		"0X" + rfcHexSecret,
+		"3132 3334 3536 3738 3930 3132 3334 3536 3738 3930",
+		"3132-3334-3536-3738-3930.3132.3334.3536.3738.3930",
+	} {
+		got, err := DecodeHexSecret(secret)
+		if err != nil {
+			t.Errorf("DecodeHexSecret(%q): %v", secret, err)
+			continue
+		}
+		if string(got) != string(want) {
+			t.Errorf("DecodeHexSecret(%q) = %q, want %q", secret, got, want)
+		}
+	}
+}
+
+func TestDecodeHexSecretErrors(t *testing.T) {
+	tests := []struct {
+		secret string
+		want   string
+	}{
+		{"313", "invalid hex secret: odd number of hex digits (3), each byte takes two"},
+		{"31g2", `invalid hex secret: character 'g' at position 3 is not a hex digit (0-9, a-f)`},
+		{"", "invalid hex secret: no hex digits"},
+		{"0x", "invalid hex secret: no hex digits"},
+		{"ab0xcd", `invalid hex secret: character 'x' at position 4 is not a hex digit (0-9, a-f)`},
+		{"0x0x31", `invalid hex secret: character 'x' at position 4 is not a hex digit (0-9, a-f)`},
+		{"x031", `invalid hex secret: character 'x' at position 1 is not a hex digit (0-9, a-f)`},
+	}
+	for _, tt := range tests {
+		_, err := DecodeHexSecret(tt.secret)
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("DecodeHexSecret(%q) error = %v, want %q", tt.secret, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package totp
+
+import (
+	"strings"
+	"sync"
+)
+
+// KeyCache remembers decoded secrets, so generating many codes from the same
+// secrets (say, several time steps for each of a large config's users)
+// normalizes and decodes each one only once. The zero value is ready to use,
+// and it's safe for concurrent use.
+type KeyCache struct {
+	mu   sync.Mutex
+	keys map[string][]byte
+}
+
+// Decode returns the key bytes of a base32 secret like DecodeSecret,
+// reusing the result of an earlier call for the same secret
+func (c *KeyCache) Decode(secret string) ([]byte, error) {
+	return c.DecodeKey(secret, EncodingBase32)
+}
+
+// DecodeKey returns the key bytes of a secret in the given encoding like
+// the DecodeKey function, reusing the result of an earlier call
+func (c *KeyCache) DecodeKey(secret, encoding string) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	encoding = strings.ToLower(encoding)
+	if encoding == "" {
+		encoding = EncodingBase32
+	}
+	cacheKey := encoding + "\x00" + secret
+	if key, ok := c.keys[cacheKey]; ok {
+		return key, nil
+	}
+
+	key, err := DecodeKey(secret, encoding)
+	if err != nil {
+		return nil, err
+	}
+	if c.keys == nil {
+		c.keys = make(map[string][]byte)
+	}
+	c.keys[cacheKey] = key
+	return key, nil
+}
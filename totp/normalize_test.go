@@ -0,0 +1,53 @@
+package totp
+
+import (
+	"bytes"
+	"encoding/base32"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeSecretPadding(t *testing.T) {
+	tests := []struct {
+		secret string
+		want   string
+	}{
+		{"MY", "MY======"},
+		{"MZXQ", "MZXQ===="},
+		{"MZXW6", "MZXW6==="},
+		{"MZXW6YQ", "MZXW6YQ="},
+		{"MZXW6YTB", "MZXW6YTB"},
+		{"MZXW6YTBOI", "MZXW6YTBOI======"},
+		{"JBSWY3DPEHPK3PXP", "JBSWY3DPEHPK3PXP"},
+		// Padding that's already there, or too much of it, is redone
+		{"MZXW6===", "MZXW6==="},
+		{"MZXW6=====", "MZXW6==="},
+		{"mzxw6yq", "MZXW6YQ="},
+		{" mzxw 6yq ", "MZXW6YQ="},
+	}
+	for _, tt := range tests {
+		if got := NormalizeSecret(tt.secret); got != tt.want {
+			t.Errorf("NormalizeSecret(%q) = %q, want %q", tt.secret, got, tt.want)
+		}
+	}
+}
+
+// Every key length gives a secret length that's a different remainder mod 8
+// once the padding is dropped, and all of them must decode
+func TestDecodeUnpaddedSecrets(t *testing.T) {
+	for length := 1; length <= 20; length++ {
+		key := bytes.Repeat([]byte{0xA5}, length)
+		padded := base32.StdEncoding.EncodeToString(key)
+		unpadded := strings.TrimRight(padded, "=")
+		for _, secret := range []string{padded, unpadded} {
+			got, err := DecodeSecret(secret)
+			if err != nil {
+				t.Errorf("DecodeSecret(%q) (%d characters): %v", secret, len(secret), err)
+				continue
+			}
+			if !bytes.Equal(got, key) {
+				t.Errorf("DecodeSecret(%q) = %x, want %x", secret, got, key)
+			}
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package totp
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestGroupedSecrets(t *testing.T) {
+	want, err := DecodeSecret("JBSWY3DPEHPK3PXP")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantCode, err := Generate("JBSWY3DPEHPK3PXP", Options{Time: time.Unix(59, 0)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, secret := range []string{
+		"jbsw-y3dp-ehpk-3pxp",
+		"JBSW-Y3DP-EHPK-3PXP",
+		"jbsw y3dp ehpk 3pxp",
+		"jbsw.y3dp.ehpk.3pxp",
+		"jbsw_y3dp_ehpk_3pxp",
+		"JBSW\tY3DP\nEHPK 3PXP\n",
+		"jbswy3dp-ehpk3pxp",
+		"-jbsw--y3dp-ehpk-3pxp-",
+	} {
+		got, err := DecodeSecret(secret)
+		if err != nil {
+			t.Errorf("DecodeSecret(%q): %v", secret, err)
+			continue
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("DecodeSecret(%q) = %x, want %x", secret, got, want)
+		}
+		if code, err := Generate(secret, Options{Time: time.Unix(59, 0)}); err != nil || code != wantCode {
+			t.Errorf("Generate(%q) = %s, %v, want %s", secret, code, err, wantCode)
+		}
+	}
+}
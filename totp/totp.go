@@ -0,0 +1,354 @@
+// Package totp generates one-time passwords: time-based TOTP codes (RFC
+// 6238), counter-based HOTP codes (RFC 4226) and Steam Guard codes.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Default parameters, used for any Options field left at its zero value
+const (
+	DefaultDigits    = 6
+	MinDigits        = 6
+	MaxDigits        = 8
+	DefaultPeriod    = 30
+	DefaultAlgorithm = "SHA1"
+)
+
+// MinKeyLength is the shortest key, in bytes, that's considered safe: the 80
+// bits of a 16 character base32 secret, which most services hand out. Shorter
+// keys still generate codes, but usually come from a truncated paste.
+const MinKeyLength = 10
+
+// Secret encodings. Secrets are base32 unless Options.Encoding says otherwise.
+// EncodingBase32Hex is RFC 4648's "extended hex" base32 alphabet (0-9, A-V),
+// which a few systems use instead of the standard one.
+const (
+	EncodingBase32    = "base32"
+	EncodingBase32Hex = "base32hex"
+	EncodingHex       = "hex"
+)
+
+// Steam Guard codes are SteamCodeLength characters from Steam's own alphabet
+const (
+	steamAlphabet   = "23456789BCDFGHJKMNPQRTVWXY"
+	SteamCodeLength = 5
+)
+
+// Options are the parameters of a generated code
+type Options struct {
+	// Digits is the code length, between MinDigits and MaxDigits
+	Digits int
+	// Period is the TOTP time step in seconds
+	Period int
+	// Algorithm is the HMAC hash: SHA1, SHA256 or SHA512
+	Algorithm string
+	// Encoding is how the secret is written: EncodingBase32 (if empty),
+	// EncodingBase32Hex or EncodingHex
+	Encoding string
+	// Time is the moment to generate a TOTP code for, the current time if zero
+	Time time.Time
+	// Keys, if set, caches decoded secrets across calls
+	Keys *KeyCache
+}
+
+// withDefaults fills in the zero fields of the options
+func (o Options) withDefaults() Options {
+	if o.Digits == 0 {
+		o.Digits = DefaultDigits
+	}
+	if o.Period == 0 {
+		o.Period = DefaultPeriod
+	}
+	if o.Algorithm == "" {
+		o.Algorithm = DefaultAlgorithm
+	}
+	if o.Time.IsZero() {
+		o.Time = time.Now()
+	}
+	return o
+}
+
+// timeStep returns the number of periods between the Unix epoch and o.Time
+func (o Options) timeStep() (uint64, error) {
+	if o.Period <= 0 {
+		return 0, fmt.Errorf("invalid period %d (must be a positive number of seconds)", o.Period)
+	}
+	if o.Time.Unix() < 0 {
+		return 0, fmt.Errorf("invalid time %v (must not be before the Unix epoch)", o.Time)
+	}
+	return uint64(o.Time.Unix() / int64(o.Period)), nil
+}
+
+// Generate returns the TOTP code for a base32 secret at opts.Time
+func Generate(secret string, opts Options) (string, error) {
+	opts = opts.withDefaults()
+	step, err := opts.timeStep()
+	if err != nil {
+		return "", err
+	}
+	return GenerateHOTP(secret, step, opts)
+}
+
+// GenerateHOTP returns the HOTP code for a base32 secret and counter value.
+// opts.Period and opts.Time are ignored.
+func GenerateHOTP(secret string, counter uint64, opts Options) (string, error) {
+	opts = opts.withDefaults()
+	if opts.Digits < MinDigits || opts.Digits > MaxDigits {
+		return "", fmt.Errorf("unsupported digit count %d (must be between %d and %d)", opts.Digits, MinDigits, MaxDigits)
+	}
+	truncatedHash, err := truncatedHMAC(secret, opts, counter)
+	if err != nil {
+		return "", err
+	}
+
+	// Reduce to the requested number of digits
+	modulus := uint32(1)
+	for i := 0; i < opts.Digits; i++ {
+		modulus *= 10
+	}
+	code := truncatedHash % modulus
+
+	return fmt.Sprintf("%0*d", opts.Digits, code), nil
+}
+
+// GenerateSteam returns the Steam Guard code for a base32 secret at
+// opts.Time. It uses the same HMAC and truncation as Generate but spells the
+// result in Steam's alphabet, so opts.Digits is ignored.
+func GenerateSteam(secret string, opts Options) (string, error) {
+	opts = opts.withDefaults()
+	step, err := opts.timeStep()
+	if err != nil {
+		return "", err
+	}
+	truncatedHash, err := truncatedHMAC(secret, opts, step)
+	if err != nil {
+		return "", err
+	}
+
+	code := make([]byte, SteamCodeLength)
+	for i := range code {
+		code[i] = steamAlphabet[truncatedHash%uint32(len(steamAlphabet))]
+		truncatedHash /= uint32(len(steamAlphabet))
+	}
+	return string(code), nil
+}
+
+// HashFunc returns the hash constructor for an HMAC algorithm name
+func HashFunc(algorithm string) (func() hash.Hash, error) {
+	switch strings.ToUpper(algorithm) {
+	case "SHA1":
+		return sha1.New, nil
+	case "SHA256":
+		return sha256.New, nil
+	case "SHA512":
+		return sha512.New, nil
+	default:
+		return nil, fmt.Errorf("unsupported algorithm '%s' (must be SHA1, SHA256, or SHA512)", algorithm)
+	}
+}
+
+// NormalizeSecret cleans up a base32 secret as typically shown by services:
+// whitespace and the hyphens, dots and underscores used to group characters
+// are removed, letters are uppercased, and missing '=' padding is restored
+// to a multiple of 8 characters
+func NormalizeSecret(secret string) string {
+	secret = strings.ToUpper(strings.Map(func(r rune) rune {
+		if isSecretSeparator(r) {
+			return -1
+		}
+		return r
+	}, secret))
+	secret = strings.TrimRight(secret, "=")
+	if rem := len(secret) % 8; rem != 0 {
+		secret += strings.Repeat("=", 8-rem)
+	}
+	return secret
+}
+
+// base32Confusables maps characters outside the base32 alphabet to the
+// letter they're usually mistaken for
+var base32Confusables = map[rune]rune{'0': 'O', '1': 'I', '8': 'B'}
+
+// isSecretSeparator reports whether NormalizeSecret drops r
+func isSecretSeparator(r rune) bool {
+	return unicode.IsSpace(r) || r == '-' || r == '.' || r == '_'
+}
+
+// checkAlphabet returns an error naming the first character of the secret
+// that isn't in the base32 alphabet and its 1-based position, so pasted
+// typos like '0' for 'O' are easy to spot
+func checkAlphabet(secret string) error {
+	position := 0
+	for _, r := range secret {
+		position++
+		upper := unicode.ToUpper(r)
+		if isSecretSeparator(r) || upper == '=' || (upper >= 'A' && upper <= 'Z') || (upper >= '2' && upper <= '7') {
+			continue
+		}
+		if letter, ok := base32Confusables[r]; ok {
+			return fmt.Errorf("character '%c' at position %d is not in the base32 alphabet (A-Z, 2-7), did you mean '%c'?", r, position, letter)
+		}
+		return fmt.Errorf("character %q at position %d is not in the base32 alphabet (A-Z, 2-7)", r, position)
+	}
+	return nil
+}
+
+// DecodeSecret returns the key bytes of a base32 secret, after cleaning it up
+// with NormalizeSecret
+func DecodeSecret(secret string) ([]byte, error) {
+	if err := checkAlphabet(secret); err != nil {
+		return nil, fmt.Errorf("invalid base32 secret: %v", err)
+	}
+	key, err := base32.StdEncoding.DecodeString(NormalizeSecret(secret))
+	if err != nil {
+		return nil, fmt.Errorf("invalid base32 secret: %v", err)
+	}
+	return key, nil
+}
+
+// DecodeBase32HexSecret returns the key bytes of a secret in the base32hex
+// alphabet, cleaned up with NormalizeSecret like a base32 one
+func DecodeBase32HexSecret(secret string) ([]byte, error) {
+	position := 0
+	for _, r := range secret {
+		position++
+		upper := unicode.ToUpper(r)
+		if !isSecretSeparator(r) && upper != '=' && !(upper >= '0' && upper <= '9') && !(upper >= 'A' && upper <= 'V') {
+			return nil, fmt.Errorf("invalid base32hex secret: character %q at position %d is not in the base32hex alphabet (0-9, A-V)", r, position)
+		}
+	}
+	key, err := base32.HexEncoding.DecodeString(NormalizeSecret(secret))
+	if err != nil {
+		return nil, fmt.Errorf("invalid base32hex secret: %v", err)
+	}
+	return key, nil
+}
+
+// DecodeHexSecret returns the key bytes of a hex secret. Whitespace, the
+// separators NormalizeSecret drops and a "0x" prefix are ignored, and either
+// case is accepted.
+func DecodeHexSecret(secret string) ([]byte, error) {
+	normalized := normalizeHex(secret)
+	if normalized == "" {
+		return nil, fmt.Errorf("invalid hex secret: no hex digits")
+	}
+	position := 0
+	var digits []rune
+	for _, r := range secret {
+		position++
+		if isSecretSeparator(r) {
+			continue
+		}
+		digits = append(digits, r)
+		// x is only allowed as part of a leading 0x, which normalizeHex strips
+		if (r == 'x' || r == 'X') && len(digits) == 2 && digits[0] == '0' {
+			continue
+		}
+		if !strings.ContainsRune("0123456789abcdefABCDEF", r) {
+			return nil, fmt.Errorf("invalid hex secret: character %q at position %d is not a hex digit (0-9, a-f)", r, position)
+		}
+	}
+	if len(normalized)%2 != 0 {
+		return nil, fmt.Errorf("invalid hex secret: odd number of hex digits (%d), each byte takes two", len(normalized))
+	}
+	key, err := hex.DecodeString(normalized)
+	if err != nil {
+		return nil, fmt.Errorf("invalid hex secret: %v", err)
+	}
+	return key, nil
+}
+
+// normalizeHex strips a hex secret down to its lowercase digits
+func normalizeHex(secret string) string {
+	secret = strings.ToLower(strings.Map(func(r rune) rune {
+		if isSecretSeparator(r) {
+			return -1
+		}
+		return r
+	}, secret))
+	return strings.TrimPrefix(secret, "0x")
+}
+
+// DecodeKey returns the key bytes of a secret in the given encoding, base32
+// if it's empty
+func DecodeKey(secret, encoding string) ([]byte, error) {
+	switch strings.ToLower(encoding) {
+	case "", EncodingBase32:
+		return DecodeSecret(secret)
+	case EncodingBase32Hex:
+		return DecodeBase32HexSecret(secret)
+	case EncodingHex:
+		return DecodeHexSecret(secret)
+	default:
+		return nil, fmt.Errorf("unsupported encoding '%s' (must be %s, %s or %s)", encoding, EncodingBase32, EncodingBase32Hex, EncodingHex)
+	}
+}
+
+// Fingerprint identifies a secret without revealing it, for confirming it
+// matches what a service showed: the last 4 characters of the secret after
+// NormalizeSecret, without padding, and the first 8 hex digits of the
+// SHA-256 of the decoded key, like "...3PXP (sha256:9b5f5e29)". It's the same
+// however the secret is spaced or cased.
+func Fingerprint(secret string) (string, error) {
+	return EncodedFingerprint(secret, EncodingBase32)
+}
+
+// EncodedFingerprint is Fingerprint for a secret in the given encoding. Hex
+// secrets show their last 4 digits, lowercased.
+func EncodedFingerprint(secret, encoding string) (string, error) {
+	key, err := DecodeKey(secret, encoding)
+	if err != nil {
+		return "", err
+	}
+	normalized := strings.TrimRight(NormalizeSecret(secret), "=")
+	if strings.EqualFold(encoding, EncodingHex) {
+		normalized = normalizeHex(secret)
+	}
+	tail := normalized[max(0, len(normalized)-4):]
+	sum := sha256.Sum256(key)
+	return fmt.Sprintf("...%s (sha256:%s)", tail, hex.EncodeToString(sum[:4])), nil
+}
+
+// truncatedHMAC computes the HMAC of the moving factor with a base32 secret
+// and applies the RFC 4226 dynamic truncation, giving a 31-bit value
+func truncatedHMAC(secret string, opts Options, movingFactor uint64) (uint32, error) {
+	newHash, err := HashFunc(opts.Algorithm)
+	if err != nil {
+		return 0, err
+	}
+
+	var key []byte
+	if opts.Keys != nil {
+		key, err = opts.Keys.DecodeKey(secret, opts.Encoding)
+	} else {
+		key, err = DecodeKey(secret, opts.Encoding)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	// Convert moving factor to bytes
+	factorBytes := make([]byte, 8)
+	binary.BigEndian.PutUint64(factorBytes, movingFactor)
+
+	// Create HMAC hash
+	h := hmac.New(newHash, key)
+	h.Write(factorBytes)
+	hash := h.Sum(nil)
+
+	// Dynamic truncation
+	offset := hash[len(hash)-1] & 0x0F
+	return binary.BigEndian.Uint32(hash[offset:offset+4]) & 0x7FFFFFFF, nil
+}
@@ -0,0 +1,71 @@
+package totp
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+)
+
+// rfcSecret returns the base32 form of an RFC test vector's ASCII key
+func rfcSecret(seed string) string {
+	return base32.StdEncoding.EncodeToString([]byte(seed))
+}
+
+// RFC 6238 appendix B uses a different key length for each algorithm
+var rfcSeeds = map[string]string{
+	"SHA1":   "12345678901234567890",
+	"SHA256": "12345678901234567890123456789012",
+	"SHA512": "1234567890123456789012345678901234567890123456789012345678901234",
+}
+
+func TestGenerateRFC6238(t *testing.T) {
+	tests := []struct {
+		time      int64
+		algorithm string
+		want      string
+	}{
+		{59, "SHA1", "94287082"},
+		{59, "SHA256", "46119246"},
+		{59, "SHA512", "90693936"},
+		{1111111109, "SHA1", "07081804"},
+		{1111111109, "SHA256", "68084774"},
+		{1111111109, "SHA512", "25091201"},
+		{1111111111, "SHA1", "14050471"},
+		{1111111111, "SHA256", "67062674"},
+		{1111111111, "SHA512", "99943326"},
+		{1234567890, "SHA1", "89005924"},
+		{1234567890, "SHA256", "91819424"},
+		{1234567890, "SHA512", "93441116"},
+		{2000000000, "SHA1", "69279037"},
+		{2000000000, "SHA256", "90698825"},
+		{2000000000, "SHA512", "38618901"},
+		{20000000000, "SHA1", "65353130"},
+		{20000000000, "SHA256", "77737706"},
+		{20000000000, "SHA512", "47863826"},
+	}
+	for _, tt := range tests {
+		opts := Options{Digits: 8, Period: 30, Algorithm: tt.algorithm, Time: time.Unix(tt.time, 0)}
+		got, err := Generate(rfcSecret(rfcSeeds[tt.algorithm]), opts)
+		if err != nil {
+			t.Errorf("Generate(%s, t=%d): %v", tt.algorithm, tt.time, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Generate(%s, t=%d) = %s, want %s", tt.algorithm, tt.time, got, tt.want)
+		}
+	}
+}
+
+func TestGenerateHOTPRFC4226(t *testing.T) {
+	want := []string{"755224", "287082", "359152", "969429", "338314", "254676", "287922", "162583", "399871", "520489"}
+	for counter, code := range want {
+		got, err := GenerateHOTP(rfcSecret(rfcSeeds["SHA1"]), uint64(counter), Options{Digits: 6})
+		if err != nil {
+			t.Errorf("GenerateHOTP(counter=%d): %v", counter, err)
+			continue
+		}
+		if got != code {
+			t.Errorf("GenerateHOTP(counter=%d) = %s, want %s", counter, got, code)
+		}
+	}
+}